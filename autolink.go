@@ -0,0 +1,145 @@
+package ssg
+
+import (
+	"html"
+	"strings"
+)
+
+// autolinks are urls and email addresses between `<` and `>`, they are always on.
+// with the linkify extension turned on, urls written as plain text (`https://...` or `www.`)
+// become links too, following the GFM rules for where they end. a link inside of the text
+// of another link is not made, and code spans are never looked at
+
+func isAutolinkSchemeChar(ch byte) bool {
+	return isASCIILetter(ch) || (ch >= '0' && ch <= '9') || ch == '+' || ch == '.' || ch == '-'
+}
+
+// isEmailAddress checks the address the way CommonMark does for autolinks
+func isEmailAddress(str string) bool {
+	at := strings.IndexByte(str, '@')
+	if at <= 0 {
+		return false
+	}
+	for i := 0; i < at; i++ {
+		ch := str[i]
+		if !isASCIILetter(ch) && !(ch >= '0' && ch <= '9') && strings.IndexByte(".!#$%&'*+/=?^_`{|}~-", ch) < 0 {
+			return false
+		}
+	}
+	for _, label := range strings.Split(str[at+1:], ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !isASCIILetter(label[i]) && !(label[i] >= '0' && label[i] <= '9') && label[i] != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseAutolink checks for `<scheme:...>` or `<address@domain>` at pos.
+// returns the html and the position of the `>`
+func parseAutolink(str string, pos int) (string, int, bool) {
+	end := strings.IndexAny(str[pos+1:], "<> \t\n")
+	if end < 0 || str[pos+1+end] != '>' {
+		return "", pos, false
+	}
+	inner := str[pos+1 : pos+1+end]
+	end += pos + 1
+	colon := strings.IndexByte(inner, ':')
+	if colon >= 2 && colon <= 32 && isASCIILetter(inner[0]) {
+		scheme := true
+		for i := 1; i < colon; i++ {
+			scheme = scheme && isAutolinkSchemeChar(inner[i])
+		}
+		if scheme {
+			// an entity in the url is decoded first so it is not escaped twice
+			url := html.UnescapeString(inner)
+			return "<a href=\"" + escapeHTML(url) + "\">" + escapeHTML(url) + "</a>", end, true
+		}
+	}
+	if isEmailAddress(inner) {
+		return "<a href=\"mailto:" + escapeHTML(inner) + "\">" + escapeHTML(inner) + "</a>", end, true
+	}
+	return "", pos, false
+}
+
+// isValidDomain wants at least one `.` and no `_` in the last two parts of the domain
+func isValidDomain(domain string) bool {
+	parts := strings.Split(domain, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for i, part := range parts {
+		if part == "" {
+			return false
+		}
+		if i >= len(parts)-2 && strings.IndexByte(part, '_') >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// trimURL drops the punctuation that is more likely to end the sentence than the url
+func trimURL(url string) string {
+	for url != "" {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte("?!.,:*_~'\"", last) >= 0:
+			url = url[:len(url)-1]
+		case last == ')' && strings.Count(url, ")") > strings.Count(url, "("):
+			url = url[:len(url)-1]
+		case last == ';':
+			// `&hellip;` at the end is an entity and not a part of the url
+			amp := strings.LastIndexByte(url, '&')
+			if amp < 0 || strings.Trim(url[amp+1:len(url)-1], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+				return url
+			}
+			url = url[:amp]
+		default:
+			return url
+		}
+	}
+	return url
+}
+
+// parseBareURL checks for a url written as text at pos, starting with `http://`, `https://`
+// or `www.`. returns the html and the position of the last character of the url
+func parseBareURL(str string, pos int) (string, int, bool) {
+	if pos > 0 && strings.IndexByte(" \t\n*_~(", str[pos-1]) < 0 {
+		return "", pos, false
+	}
+	rest := str[pos:]
+	prefix := ""
+	switch {
+	case strings.HasPrefix(rest, "https://"):
+		rest = rest[len("https://"):]
+	case strings.HasPrefix(rest, "http://"):
+		rest = rest[len("http://"):]
+	case strings.HasPrefix(rest, "www."):
+		prefix = "http://"
+	default:
+		return "", pos, false
+	}
+	domainEnd := 0
+	for domainEnd < len(rest) && (isASCIILetter(rest[domainEnd]) || (rest[domainEnd] >= '0' && rest[domainEnd] <= '9') ||
+		strings.IndexByte("._-", rest[domainEnd]) >= 0 || rest[domainEnd] >= 0x80) {
+		domainEnd++
+	}
+	if !isValidDomain(strings.TrimRight(rest[:domainEnd], ".")) {
+		return "", pos, false
+	}
+	end := strings.IndexAny(str[pos:], " \t\n<")
+	if end < 0 {
+		end = len(str) - pos
+	}
+	url := trimURL(str[pos : pos+end])
+	if len(url) <= len(str[pos:])-len(rest) {
+		return "", pos, false
+	}
+	text := html.UnescapeString(url)
+	return "<a href=\"" + escapeHTML(prefix+text) + "\">" + escapeHTML(text) + "</a>", pos + len(url) - 1, true
+}
@@ -0,0 +1,519 @@
+package ssg
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// container blocks (blockquotes and list items) are parsed in two steps. First the lines
+// belonging to the container are collected with the container markers removed, then the
+// collected text is run through the block parser again as if it was a document of its own.
+
+// lineAt returns the line starting at pos including its newline and the position of
+// the last character of that line
+func lineAt(str string, pos int) (string, int) {
+	end := strings.IndexByte(str[pos:], '\n')
+	if end < 0 {
+		return str[pos:], len(str) - 1
+	}
+	return str[pos : pos+end+1], pos + end
+}
+
+func isBlank(line string) bool {
+	return strings.Trim(line, " \t\n") == ""
+}
+
+// dedent removes up to n columns of leading whitespace. a tab that goes past n
+// is replaced by the spaces left over
+func dedent(line string, n int) string {
+	col := 0
+	for i := 0; i < len(line); i++ {
+		if col >= n {
+			return line[i:]
+		}
+		switch line[i] {
+		case ' ':
+			col++
+		case '\t':
+			next := col + 4 - col%4
+			if next > n {
+				return strings.Repeat(" ", next-n) + line[i+1:]
+			}
+			col = next
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+// isThematicBreak checks if the line is 3 or more `-`, `*` or `_`, all of them the same,
+// with nothing but spaces in between. it decides before a list item does, `* * *` is a break
+func isThematicBreak(line string) bool {
+	if lineIndent(line) > 3 {
+		return false
+	}
+	line = strings.Trim(line, " \t\n")
+	if line == "" || (line[0] != '-' && line[0] != '*' && line[0] != '_') {
+		return false
+	}
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case line[0]:
+			n++
+		case ' ', '\t':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// parseFence checks if the line opens a fenced code block with ``` or ~~~.
+// returns the fence character, the length of the fence and the info string
+func parseFence(line string) (byte, int, string, bool) {
+	if lineIndent(line) > 3 {
+		return 0, 0, "", false
+	}
+	line = strings.TrimLeft(line, " ")
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return 0, 0, "", false
+	}
+	n := countRun(line, 0, line[0])
+	if n < 3 {
+		return 0, 0, "", false
+	}
+	info := strings.Trim(line[n:], " \t\n")
+	if line[0] == '`' && strings.IndexByte(info, '`') >= 0 {
+		// the info string of a backtick fence can not hold a backtick, this is inline code
+		return 0, 0, "", false
+	}
+	return line[0], n, info, true
+}
+
+// closesFence checks if the line is a closing fence for an opening fence of n `ch`
+func closesFence(line string, ch byte, n int) bool {
+	if lineIndent(line) > 3 {
+		return false
+	}
+	line = strings.TrimLeft(line, " ")
+	m := countRun(line, 0, ch)
+	return m >= n && strings.Trim(line[m:], " \t\n") == ""
+}
+
+// parseCodeFence parses a fenced code block. when the closing fence is missing
+// the block runs to the end of the input
+func parseCodeFence(str string, pos int) (res parsedToken) {
+	res.pos = pos
+	line, end := lineAt(str, pos)
+	ch, n, info, ok := parseFence(line)
+	if !ok {
+		res.statusCode = parseError
+		res.statusMessage = "not a code fence"
+		return res
+	}
+	indent := lineIndent(line)
+	res.pos = end
+	code := ""
+	for i := end + 1; i < len(str); {
+		line, end = lineAt(str, i)
+		res.pos = end
+		i = end + 1
+		if closesFence(line, ch, n) {
+			break
+		}
+		code += dedent(line, indent)
+	}
+	if code != "" && !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+
+	res.str = "\n<pre><code"
+	// the language is the first word of the info string, words are split on spaces and tabs only
+	if lang, _, _ := strings.Cut(strings.ReplaceAll(info, "\t", " "), " "); lang != "" {
+		res.str += " class=\"language-" + escapeHTML(lang) + "\""
+	}
+	res.str += ">" + escapeHTML(code) + "</code></pre>\n"
+	res.statusCode = parseSuccess
+	return res
+}
+
+// parseIndentedCode parses lines indented by 4 or more columns. blank lines in between
+// are part of the code, blank lines at the end are not
+func parseIndentedCode(str string, pos int) (res parsedToken) {
+	res.pos = pos
+	code := ""
+	blanks := ""
+	for i := pos; i < len(str); {
+		line, end := lineAt(str, i)
+		i = end + 1
+		if isBlank(line) {
+			blanks += dedent(strings.TrimRight(line, "\n"), 4) + "\n"
+			continue
+		}
+		if lineIndent(line) < 4 {
+			break
+		}
+		code += blanks + dedent(line, 4)
+		blanks = ""
+		res.pos = end
+	}
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	res.str = "\n<pre><code>" + escapeHTML(code) + "</code></pre>\n"
+	res.statusCode = parseSuccess
+	return res
+}
+
+// stripQuoteMarker removes the `>` and the optional space after it. ok is false when
+// the line is not part of a blockquote
+func stripQuoteMarker(line string) (string, bool) {
+	if lineIndent(line) > 3 {
+		return line, false
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if trimmed == "" || trimmed[0] != '>' {
+		return line, false
+	}
+	trimmed = trimmed[1:]
+	if strings.HasPrefix(trimmed, " ") || strings.HasPrefix(trimmed, "\t") {
+		trimmed = trimmed[1:]
+	}
+	return trimmed, true
+}
+
+// parseBlockquote collects the lines of a blockquote with their `>` removed into res.text.
+// a line without the `>` still belongs to the quote when it continues a paragraph inside
+// of it (lazy continuation), a blank line or any other block ends the quote
+func parseBlockquote(str string, pos int) (res parsedToken) {
+	res.pos = pos
+	content := ""
+	for i := pos; i < len(str); {
+		line, end := lineAt(str, i)
+		if inner, ok := stripQuoteMarker(line); ok {
+			content += inner
+		} else if !isBlank(line) && endsInParagraph(content) && !interruptsParagraph(line) {
+			content += lazyLine(line)
+		} else {
+			break
+		}
+		res.pos = end
+		i = end + 1
+	}
+	res.text = content
+	res.statusCode = parseSuccess
+	return res
+}
+
+// lazyLine prepares a lazy continuation line for the content of a container.
+// `===` below a lazy line can not turn it into a heading, the underline is escaped
+// so that it stays text when the content is parsed
+func lazyLine(line string) string {
+	if isSetextUnderline(line) > 0 {
+		return "\\" + strings.TrimLeft(line, " ")
+	}
+	return line
+}
+
+// interruptsParagraph checks if the line starts a block that can end a paragraph
+// without an empty line in between
+func interruptsParagraph(line string) bool {
+	if lineIndent(line) > 3 {
+		return false
+	}
+	if isThematicBreak(line) {
+		return true
+	}
+	trimmed := strings.TrimLeft(line, " \t")
+	ch, _ := utf8.DecodeRuneInString(trimmed)
+	switch tokenize(ch) {
+	case tokenHeading:
+		return parseHeading(trimmed, 0).statusCode == parseSuccess
+	case tokenQuote:
+		return true
+	case tokenFence:
+		_, _, _, ok := parseFence(line)
+		return ok
+	case tokenBullet, tokenOrdered, tokenFormat:
+		marker, ok := parseListMarker(line)
+		return ok && marker.interrupts()
+	case tokenComponent:
+		_, _, _, ok := parseComponentOpener(line)
+		return ok
+	case tokenHTML:
+		return htmlBlockStart(line, true) > 0
+	}
+	return false
+}
+
+// endsInParagraph tells if the container content ends on a line of paragraph text,
+// looking through nested quotes and list markers
+func endsInParagraph(content string) bool {
+	para := false
+	var fenceCh byte
+	fenceN := 0
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(content, "\n"), "\n") {
+		for {
+			inner, ok := stripQuoteMarker(line)
+			if !ok {
+				break
+			}
+			line = inner
+		}
+		if fenceN > 0 {
+			if closesFence(line, fenceCh, fenceN) {
+				fenceN = 0
+			}
+			para = false
+			continue
+		}
+		if isBlank(line) {
+			para = false
+			continue
+		}
+		if lineIndent(line) >= 4 && !para {
+			// indented code
+			continue
+		}
+		if ch, n, _, ok := parseFence(line); ok {
+			fenceCh, fenceN = ch, n
+			para = false
+			continue
+		}
+		if marker, ok := parseListMarker(line); ok {
+			line = marker.itemContent(line)
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		if isBlank(trimmed) || (trimmed[0] == '#' && parseHeading(trimmed, 0).statusCode == parseSuccess) {
+			para = false
+			continue
+		}
+		if para && isSetextUnderline(line) > 0 {
+			para = false
+			continue
+		}
+		if isThematicBreak(line) {
+			para = false
+			continue
+		}
+		para = true
+	}
+	return para
+}
+
+type listMarker struct {
+	ordered bool
+	// the bullet character or the delimiter after the number, `.` or `)`
+	char  byte
+	start int
+	// columns from the start of the line to the content of the item
+	width int
+	// nothing was written after the marker
+	empty bool
+	// byte position right after the marker and the column it is at
+	end    int
+	endCol int
+}
+
+// an empty item can not end a paragraph, and an ordered list can only do it
+// when it starts at 1
+func (marker listMarker) interrupts() bool {
+	return !marker.empty && (!marker.ordered || marker.start == 1)
+}
+
+// parseListMarker checks if the line starts with `-`, `+`, `*` or a number followed by `.` or `)`
+func parseListMarker(line string) (listMarker, bool) {
+	var marker listMarker
+	indent := lineIndent(line)
+	if indent > 3 {
+		return marker, false
+	}
+	rest := strings.TrimLeft(line, " \t")
+	n := 0
+	switch {
+	case rest == "":
+		return marker, false
+	case rest[0] == '-' || rest[0] == '+' || rest[0] == '*':
+		marker.char = rest[0]
+		n = 1
+	default:
+		for n < len(rest) && n < 9 && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(rest) || (rest[n] != '.' && rest[n] != ')') {
+			return marker, false
+		}
+		marker.ordered = true
+		marker.start, _ = strconv.Atoi(rest[:n])
+		marker.char = rest[n]
+		n++
+	}
+	after := rest[n:]
+	marker.end = len(line) - len(rest) + n
+	marker.endCol = indent + n
+	if isBlank(after) {
+		marker.empty = true
+		marker.width = indent + n + 1
+		return marker, true
+	}
+	if after[0] != ' ' && after[0] != '\t' {
+		return marker, false
+	}
+	spaces := lineIndent(after)
+	if spaces > 4 {
+		// the content is indented code, only the first space belongs to the marker
+		spaces = 1
+	}
+	marker.width = indent + n + spaces
+	return marker, true
+}
+
+// itemContent returns the first line of the item with the marker removed
+func (marker listMarker) itemContent(line string) string {
+	return dedent(strings.Repeat(" ", marker.endCol)+line[marker.end:], marker.width)
+}
+
+// parsedList is a list with the content of every item collected the same way
+// a blockquote collects its content
+type parsedList struct {
+	parsedToken
+	ordered bool
+	start   int
+	// items are separated by empty lines, their paragraphs get wrapped in <p>
+	loose bool
+	items []string
+}
+
+// parseList collects the items of a list, all of them using the same kind of marker.
+// the content of an item is every line indented at least as far as its text, plus
+// lazy continuation lines of its last paragraph
+func parseList(str string, pos int) (res parsedList) {
+	res.pos = pos
+	line, end := lineAt(str, pos)
+	marker, ok := parseListMarker(line)
+	if !ok {
+		res.statusCode = parseError
+		res.statusMessage = "not a list item"
+		return res
+	}
+	res.ordered = marker.ordered
+	res.start = marker.start
+	res.pos = end
+
+	item := marker.itemContent(line)
+	width := marker.width
+	blanks := ""
+	for i := end + 1; i < len(str); {
+		line, end = lineAt(str, i)
+		i = end + 1
+		if isBlank(line) {
+			blanks += "\n"
+			continue
+		}
+		if lineIndent(line) >= width && !(isBlank(item) && blanks != "") {
+			// more content for the current item. an empty line between two blocks
+			// of the item itself makes the list loose
+			inner := dedent(line, width)
+			if blanks != "" && startsItemBlock(item, inner) {
+				res.loose = true
+			}
+			item += blanks + inner
+		} else if next, ok := parseListMarker(line); ok && next.ordered == marker.ordered && next.char == marker.char && !isThematicBreak(line) {
+			if blanks != "" {
+				res.loose = true
+			}
+			res.items = append(res.items, item)
+			item = next.itemContent(line)
+			width = next.width
+		} else if blanks == "" && endsInParagraph(item) && !interruptsParagraph(line) {
+			item += lazyLine(line)
+		} else {
+			break
+		}
+		blanks = ""
+		res.pos = end
+	}
+	res.items = append(res.items, item)
+	res.statusCode = parseSuccess
+	return res
+}
+
+// taskCount is how many task list items of a page are done and how many are still open
+type taskCount struct {
+	Done int
+	Open int
+}
+
+func (count taskCount) Total() int {
+	return count.Done + count.Open
+}
+
+// taskMarker checks if a list item starts with `[ ]` or `[x]` followed by whitespace.
+// returns the content of the item without the marker
+func taskMarker(item string) (bool, bool, string) {
+	if len(item) < 4 || item[0] != '[' || item[2] != ']' || (item[3] != ' ' && item[3] != '\t') {
+		return false, false, item
+	}
+	switch item[1] {
+	case ' ':
+		return true, false, item[4:]
+	case 'x', 'X':
+		return true, true, item[4:]
+	}
+	return false, false, item
+}
+
+// startsItemBlock tells if inner, written after an empty line in an item, starts another block
+// of the item itself, which makes the list loose. it does not when it goes on with indented code
+// or with a list nested in the item, the empty line is then inside of that block
+func startsItemBlock(item string, inner string) bool {
+	if insideFence(item) {
+		return false
+	}
+	indent := lineIndent(inner)
+	lines := strings.Split(strings.TrimRight(item, "\n"), "\n")
+	// indented code at the end of the item, it starts after an empty line
+	k := len(lines) - 1
+	for k >= 0 && (isBlank(lines[k]) || lineIndent(lines[k]) >= 4) {
+		k--
+	}
+	if indent >= 4 && k < len(lines)-1 && (k < 0 || isBlank(lines[k+1])) {
+		return false
+	}
+	next, isMarker := parseListMarker(inner)
+	for j := k; j >= 0; j-- {
+		line := lines[j]
+		if isBlank(line) {
+			continue
+		}
+		if marker, ok := parseListMarker(line); ok && !isThematicBreak(line) {
+			// the line is more content of a nested item or another item of the nested list
+			if indent >= marker.width || (isMarker && next.char == marker.char && next.ordered == marker.ordered) {
+				return false
+			}
+		}
+		if lineIndent(line) == 0 {
+			return true
+		}
+	}
+	return true
+}
+
+// insideFence tells if the content ends inside an open fenced code block
+func insideFence(content string) bool {
+	var fenceCh byte
+	fenceN := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if fenceN > 0 {
+			if closesFence(line, fenceCh, fenceN) {
+				fenceN = 0
+			}
+		} else if ch, n, _, ok := parseFence(line); ok {
+			fenceCh, fenceN = ch, n
+		}
+	}
+	return fenceN > 0
+}
@@ -0,0 +1,12 @@
+// Command ssg builds a static site from a directory of markdown files, see the README
+package main
+
+import (
+	"os"
+
+	"ssg"
+)
+
+func main() {
+	os.Exit(ssg.Main(os.Args[1:]))
+}
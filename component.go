@@ -0,0 +1,262 @@
+package ssg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// components are reusable pieces of html (callouts, video embeds, ...) written as html/template
+// files, one file per component inside the directory set by "components" in the config.
+// a block component wraps markdown which is converted first and handed to the template:
+//
+//	:::card title="Pricing" featured
+//	the **inner** markdown
+//	:::
+//
+// a component inside of a component closes on its own `:::`, or the outer one can use
+// more colons to make it easier to read. the inline form has no inner markdown:
+//
+//	watch {{< youtube id="dQw4w9WgXcQ" >}} first
+//
+// the template for `card` is card.html and it gets componentData to work with
+
+// componentData is what the template of a component gets to work with
+type componentData struct {
+	Name string
+	// arguments written as key="value"
+	Args map[string]string
+	// arguments written without a key, in the order they were written
+	Params []string
+	// the converted markdown between the opening and the closing `:::`
+	Inner template.HTML
+}
+
+func isComponentNameChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_'
+}
+
+// parseComponentArgs splits `title="a b" featured size=3` into the arguments of a component
+func parseComponentArgs(name string, str string) componentData {
+	data := componentData{Name: name, Args: map[string]string{}}
+	for i := 0; i < len(str); {
+		if str[i] == ' ' || str[i] == '\t' {
+			i++
+			continue
+		}
+		key := ""
+		start := i
+		for i < len(str) && isComponentNameChar(str[i]) {
+			i++
+		}
+		if i < len(str) && str[i] == '=' && i > start {
+			key = str[start:i]
+			i++
+		} else {
+			i = start
+		}
+		value := ""
+		if i < len(str) && (str[i] == '"' || str[i] == '\'') {
+			end := strings.IndexByte(str[i+1:], str[i])
+			if end < 0 {
+				end = len(str) - i - 1
+			}
+			value = str[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			end := strings.IndexAny(str[i:], " \t")
+			if end < 0 {
+				end = len(str) - i
+			}
+			value = str[i : i+end]
+			i += end
+		}
+		if key != "" {
+			data.Args[key] = value
+		} else {
+			data.Params = append(data.Params, value)
+		}
+	}
+	return data
+}
+
+// parseComponentOpener checks if the line opens a block component with 3 or more `:`.
+// returns the number of colons, the name and the arguments after it
+func parseComponentOpener(line string) (int, string, string, bool) {
+	if lineIndent(line) > 3 {
+		return 0, "", "", false
+	}
+	line = strings.Trim(line, " \t\n")
+	n := countRun(line, 0, ':')
+	if n < 3 {
+		return 0, "", "", false
+	}
+	rest := strings.TrimLeft(line[n:], " \t")
+	end := 0
+	for end < len(rest) && isComponentNameChar(rest[end]) {
+		end++
+	}
+	if end == 0 || (end < len(rest) && rest[end] != ' ' && rest[end] != '\t') {
+		return 0, "", "", false
+	}
+	return n, rest[:end], strings.Trim(rest[end:], " \t"), true
+}
+
+// parseComponentCloser checks if the line is nothing but 3 or more `:` and returns how many
+func parseComponentCloser(line string) int {
+	if lineIndent(line) > 3 {
+		return 0
+	}
+	line = strings.Trim(line, " \t\n")
+	n := countRun(line, 0, ':')
+	if n < 3 || n != len(line) {
+		return 0
+	}
+	return n
+}
+
+type parsedComponent struct {
+	parsedToken
+	data componentData
+}
+
+// parseComponent collects the inner markdown of a block component into res.text.
+// lines inside of a fenced code block never open or close a component. when the
+// closing `:::` is missing the component runs to the end of the input
+func parseComponent(str string, pos int) (res parsedComponent) {
+	res.pos = pos
+	line, end := lineAt(str, pos)
+	n, name, args, ok := parseComponentOpener(line)
+	if !ok {
+		res.statusCode = parseError
+		res.statusMessage = "not a component"
+		return res
+	}
+	res.data = parseComponentArgs(name, args)
+	res.pos = end
+	depth := 0
+	var fenceCh byte
+	fenceN := 0
+	closed := false
+	for i := end + 1; i < len(str) && !closed; {
+		line, end = lineAt(str, i)
+		res.pos = end
+		i = end + 1
+		if fenceN > 0 {
+			if closesFence(line, fenceCh, fenceN) {
+				fenceN = 0
+			}
+		} else if ch, m, _, ok := parseFence(line); ok {
+			fenceCh, fenceN = ch, m
+		} else if _, _, _, ok := parseComponentOpener(line); ok {
+			depth++
+		} else if m := parseComponentCloser(line); m > 0 {
+			if depth == 0 && m >= n {
+				closed = true
+				break
+			}
+			depth = clampFloor(depth-1, 0)
+		}
+		res.text += line
+	}
+	res.statusCode = parseSuccess
+	if !closed {
+		res.pos = len(str) - 1
+		res.statusCode = parseWarning
+		res.statusMessage = "the component `" + name + "` is never closed with `" + strings.Repeat(":", n) + "`, it runs to the end of the document"
+		res.rule = "component-unclosed"
+	}
+	return res
+}
+
+// component returns the template of a component, templates are read the first time they are used
+func (conf *Config) component(name string) (*template.Template, error) {
+	if tmpl, ok := conf.components[name]; ok {
+		return tmpl, nil
+	}
+	if conf.Components == "" {
+		return nil, errors.New("unknown component `" + name + "`, no components directory is configured")
+	}
+	path := filepath.Join(conf.Components, name+".html")
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("unknown component `%s`, %s does not exist", name, path)
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse component `%s`: %v", name, err)
+	}
+	if conf.components == nil {
+		conf.components = map[string]*template.Template{}
+	}
+	conf.components[name] = tmpl
+	return tmpl, nil
+}
+
+// renderComponent runs the template of a component. pos is where the component was
+// written, a component that can not be rendered is reported there
+func (state *parserState) renderComponent(data componentData, pos int) (string, bool) {
+	var info parsedToken
+	info.pos = pos
+	info.statusCode = parseError
+	info.rule = "component-template"
+	tmpl, err := state.conf.component(data.Name)
+	if err != nil {
+		info.statusMessage = err.Error()
+		state.report(info)
+		return "", false
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		info.statusMessage = "failed to render component `" + data.Name + "`: " + err.Error()
+		state.report(info)
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// writeComponent converts the inner markdown and passes it to the template. when the
+// component can not be rendered only the inner markdown is written
+func (state *parserState) writeComponent(comp parsedComponent, pos int) string {
+	inner := state.nested(comp.text, false)
+	comp.data.Inner = template.HTML(inner)
+	html, ok := state.renderComponent(comp.data, pos)
+	if !ok {
+		return inner
+	}
+	return "\n" + html + "\n"
+}
+
+// parseInlineComponent handles `{{< name args >}}`, pos is on the first `{`
+func (state *parserState) parseInlineComponent(str string, pos int) (string, int) {
+	end := strings.Index(str[pos:], ">}}")
+	if !strings.HasPrefix(str[pos:], "{{<") || end < 0 {
+		return "{", pos
+	}
+	raw := str[pos : pos+end+3]
+	inner := strings.Trim(str[pos+3:pos+end], " \t\n")
+	name := inner
+	args := ""
+	if cut := strings.IndexAny(inner, " \t\n"); cut >= 0 {
+		name, args = inner[:cut], inner[cut+1:]
+	}
+	for i := 0; i < len(name); i++ {
+		if !isComponentNameChar(name[i]) {
+			return "{", pos
+		}
+	}
+	if name == "" {
+		return "{", pos
+	}
+	// the inline text lost its place in the input, the first time it was written is close enough
+	at := clampFloor(strings.Index(state.inpStr, raw), 0)
+	html, ok := state.renderComponent(parseComponentArgs(name, strings.ReplaceAll(args, "\n", " ")), at)
+	if !ok {
+		return escapeHTML(raw), pos + len(raw) - 1
+	}
+	return html, pos + len(raw) - 1
+}
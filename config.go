@@ -0,0 +1,195 @@
+package ssg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TocConfig controls which headings end up in the table of contents.
+// only headings with minLevel <= level <= maxLevel are listed
+type TocConfig struct {
+	MinLevel int `json:"min_level"`
+	MaxLevel int `json:"max_level"`
+}
+
+// ExtensionConfig turns on the inline extensions
+type ExtensionConfig struct {
+	// ~~text~~ to <del>
+	Strikethrough bool `json:"strikethrough"`
+	// ==text== to <mark>
+	Highlight bool `json:"highlight"`
+	// ^text^ to <sup>
+	Superscript bool `json:"superscript"`
+	// ~text~ to <sub>
+	Subscript bool `json:"subscript"`
+	// ++text++ to <ins>
+	Insert bool `json:"insert"`
+	// urls written as text, like https://example.com or www.example.com, to links
+	Linkify bool `json:"linkify"`
+}
+
+// enabled tells if the run of delimiters is an extension that is turned on
+func (conf ExtensionConfig) enabled(run string) bool {
+	switch run {
+	case "~~":
+		return conf.Strikethrough
+	case "==":
+		return conf.Highlight
+	case "^":
+		return conf.Superscript
+	case "~":
+		return conf.Subscript
+	case "++":
+		return conf.Insert
+	}
+	return false
+}
+
+// Config is read from the json file passed through the -config flag.
+// any value that is not present in the file keeps its default
+type Config struct {
+	// path to an html/template file every converted page is rendered through.
+	// when empty, the bare <article> is written out
+	Template string        `json:"template"`
+	Toc      TocConfig     `json:"toc"`
+	Headings HeadingConfig `json:"headings"`
+	// directory holding a `name.html` template for every component, see component.go
+	Components string `json:"components"`
+	// what happens to raw html in the markdown: passthrough, escape or strip
+	HTML     string         `json:"html"`
+	Sanitize SanitizeConfig `json:"sanitize"`
+	// inline formatting that is not part of CommonMark, all of it is off by default
+	Extensions ExtensionConfig `json:"extensions"`
+	// encoding of the markdown files: auto, utf-8, utf-16le, utf-16be or latin-1, see input.go
+	Encoding string `json:"encoding"`
+	// report relative links and `#id` links that lead nowhere
+	CheckLinks bool `json:"check_links"`
+	// rules of `ssg lint`, see lint.go
+	Lint LintConfig `json:"lint"`
+	// style of `ssg fmt`, see fmt.go
+	Fmt FormatConfig `json:"fmt"`
+	// settings for the files of a directory (relative to -src_dir) and the directories inside
+	// of it, or for a single file. every section is a config of its own that only lists what it changes
+	Sections map[string]json.RawMessage `json:"sections"`
+
+	layout     *template.Template
+	components map[string]*template.Template
+	sections   map[string]*Config
+}
+
+// DefaultConfig returns the config used when there is no config file
+func DefaultConfig() Config {
+	return Config{
+		Toc:        TocConfig{MinLevel: 2, MaxLevel: 3},
+		HTML:       HTMLPassthrough,
+		Encoding:   EncodingAuto,
+		CheckLinks: true,
+		Lint:       LintConfig{LineLength: 100},
+		Sanitize:   defaultSanitizeConfig(),
+		Headings: HeadingConfig{
+			Slug:           SlugConfig{Separator: "-", Lowercase: true},
+			AnchorPosition: "after",
+		},
+	}
+}
+
+// LoadConfig reads the json config file at path on top of the defaults,
+// an empty path gives the defaults
+func LoadConfig(path string) (Config, error) {
+	conf := DefaultConfig()
+	if path != "" {
+		conf_bytes, err := os.ReadFile(path)
+		if err != nil {
+			return conf, fmt.Errorf("failed to read config %s: %w", path, err)
+		}
+		err = json.Unmarshal(conf_bytes, &conf)
+		if err != nil {
+			return conf, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+	err := conf.init()
+	if err != nil {
+		return conf, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return conf, nil
+}
+
+// init checks the config and reads its sections, a config has to go through it before it is used
+func (conf *Config) init() error {
+	err := conf.setup()
+	if err != nil {
+		return err
+	}
+	conf.sections = map[string]*Config{}
+	for dir, raw := range conf.Sections {
+		// a section starts from the config it is in, the allowlist and lint rule maps are copied first
+		// since json would fill the ones both share
+		section := *conf
+		section.Sanitize.Attributes = maps.Clone(conf.Sanitize.Attributes)
+		section.Lint.Rules = maps.Clone(conf.Lint.Rules)
+		section.Sections = nil
+		section.sections = nil
+		err := json.Unmarshal(raw, &section)
+		if err != nil {
+			return fmt.Errorf("failed to parse section %s: %w", dir, err)
+		}
+		if section.Template != conf.Template {
+			section.layout = nil
+		}
+		if section.Components != conf.Components {
+			section.components = nil
+		}
+		err = section.setup()
+		if err != nil {
+			return fmt.Errorf("section %s: %w", dir, err)
+		}
+		conf.sections[filepath.Clean(dir)] = &section
+	}
+	return nil
+}
+
+// setup checks the values read from the json and loads the template
+func (conf *Config) setup() error {
+	conf.Toc.MinLevel = clampFloor(conf.Toc.MinLevel, 1)
+	conf.Toc.MaxLevel = clampCeil(conf.Toc.MaxLevel, len(hMap))
+	if conf.HTML != HTMLPassthrough && conf.HTML != HTMLEscape && conf.HTML != HTMLStrip {
+		return errors.New("invalid html mode " + conf.HTML + ", use one of passthrough, escape or strip")
+	}
+	for rule := range conf.Lint.Rules {
+		if !slices.Contains(lintRules, rule) {
+			return errors.New("unknown lint rule " + rule + ", use one of " + strings.Join(lintRules, ", "))
+		}
+	}
+	conf.Lint.LineLength = clampFloor(conf.Lint.LineLength, 1)
+	conf.Fmt.Wrap = clampFloor(conf.Fmt.Wrap, 0)
+	if !isEncoding(conf.Encoding) {
+		return errors.New("invalid encoding " + conf.Encoding + ", use one of auto, utf-8, utf-16le, utf-16be or latin-1")
+	}
+
+	if conf.Template != "" && conf.layout == nil {
+		layout, err := template.ParseFiles(conf.Template)
+		if err != nil {
+			return fmt.Errorf("failed to parse template %s: %w", conf.Template, err)
+		}
+		conf.layout = layout
+	}
+	return nil
+}
+
+// section returns the config for path, a file or directory relative to -src_dir.
+// the section closest to path wins, without one the config itself is used
+func (conf *Config) section(path string) *Config {
+	for dir := filepath.Clean(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if section, ok := conf.sections[dir]; ok {
+			return section
+		}
+	}
+	return conf
+}
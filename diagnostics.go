@@ -0,0 +1,181 @@
+package ssg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// diagnostics are printed the way compilers print them, with the line they are on and a
+// marker under the place in it:
+//
+//	posts/hello.md:3:3: warning: a heading needs a space after the `#` characters, this is written as text
+//	  |
+//	3 | ###title
+//	  |   ^~~~~~
+//
+// with colour when they go to a terminal
+
+// Diagnostic is a warning or an error found while converting a file
+type Diagnostic struct {
+	Path string `json:"file"`
+	// lines and columns start at 1, the columns count characters. the range ends after
+	// the marked characters
+	Line     int    `json:"line"`
+	Col      int    `json:"column"`
+	EndLine  int    `json:"end_line"`
+	EndCol   int    `json:"end_column"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// the line of the file the diagnostic is on and the number of characters that are marked
+	Source string `json:"-"`
+	Length int    `json:"-"`
+	// the marked bytes of the document
+	pos int
+	end int
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// diagnostics returns what was reported while converting the document, for the file at path
+func (state *parserState) diagnostics(path string) []Diagnostic {
+	src := state.doc.src
+	diags := make([]Diagnostic, 0, len(state.doc.diags))
+	for _, info := range state.doc.diags {
+		diag := Diagnostic{Path: path, Line: info.row, Col: info.col, Rule: info.rule, Severity: SeverityError, Message: info.statusMessage}
+		if info.statusCode == parseWarning {
+			diag.Severity = SeverityWarning
+		}
+		pos := clampCeil(info.pos, len(src))
+		lineStart := strings.LastIndexByte(src[:pos], '\n') + 1
+		line, _ := lineAt(src, lineStart)
+		diag.Source = strings.TrimSuffix(line, "\n")
+		// the text of the token is marked when it is written there, the word at the position otherwise
+		end := strings.IndexFunc(src[pos:], unicode.IsSpace)
+		if end < 0 {
+			end = len(src) - pos
+		}
+		if info.str != "" && strings.HasPrefix(src[pos:], info.str) {
+			end = len(strings.TrimRight(info.str, "\n"))
+		}
+		diag.Length = clampFloor(columns(src[pos:pos+end]), 1)
+		diag.EndLine, diag.EndCol = diag.Line, diag.Col+diag.Length
+		diag.pos, diag.end = pos, pos+end
+		diags = append(diags, diag)
+	}
+	// footnotes are only checked at the end, the diagnostics are put in the order of the file
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Col - b.Col
+	})
+	return diags
+}
+
+// useColor tells if the file is a terminal, NO_COLOR turns colour off
+func useColor(file *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeDiagnostic prints the diagnostic with an excerpt of the line it is on
+func writeDiagnostic(w io.Writer, diag Diagnostic, color bool) {
+	paint := func(code string, text string) string {
+		if !color {
+			return text
+		}
+		return "\x1b[" + code + "m" + text + "\x1b[0m"
+	}
+	severityColor := "1;31"
+	if diag.Severity == SeverityWarning {
+		severityColor = "1;33"
+	}
+	path := diag.Path
+	if path == "" {
+		path = "<input>"
+	}
+	fmt.Fprintf(w, "%s %s %s\n", paint("1", path+":"+strconv.Itoa(diag.Line)+":"+strconv.Itoa(diag.Col)+":"),
+		paint(severityColor, diag.Severity+":"), paint("1", diag.Message))
+
+	// the marker is lined up under the characters of the line, wide ones take two columns
+	// and tabs are kept as tabs
+	pad, marker := "", ""
+	for i, char := range graphemes(diag.Source) {
+		width := " "
+		switch {
+		case char == "\t":
+			width = "\t"
+		case isWide(char):
+			width = "  "
+		}
+		if i < diag.Col-1 {
+			pad += width
+		} else if i < diag.Col-1+diag.Length {
+			marker += strings.Repeat("~", len(width))
+		}
+	}
+	if marker == "" {
+		// the end of the line
+		marker = "~"
+	}
+	marker = "^" + marker[1:]
+	gutter := strings.Repeat(" ", len(strconv.Itoa(diag.Line)))
+	fmt.Fprintf(w, "%s\n", paint("1;34", gutter+" |"))
+	fmt.Fprintf(w, "%s %s\n", paint("1;34", strconv.Itoa(diag.Line)+" |"), diag.Source)
+	fmt.Fprintf(w, "%s %s%s\n", paint("1;34", gutter+" |"), pad,
+		paint(severityColor, marker))
+}
+
+// the formats of the --diagnostics-format flag
+const (
+	diagnosticsText  = "text"
+	diagnosticsJSON  = "json"
+	diagnosticsSARIF = "sarif"
+)
+
+func checkDiagnosticsFormat(format string) error {
+	if format != diagnosticsText && format != diagnosticsJSON && format != diagnosticsSARIF {
+		return fmt.Errorf("invalid diagnostics format: %s. Use one of text, json or sarif", format)
+	}
+	return nil
+}
+
+// printDiagnostics writes the diagnostics of every converted file. text goes to stderr,
+// the formats for other tools to read go to stdout
+func printDiagnostics(diags []Diagnostic, format string) error {
+	switch format {
+	case diagnosticsJSON:
+		return writeDiagnosticsJSON(os.Stdout, diags)
+	case diagnosticsSARIF:
+		return writeDiagnosticsSARIF(os.Stdout, diags)
+	}
+	color := useColor(os.Stderr)
+	for _, diag := range diags {
+		writeDiagnostic(os.Stderr, diag, color)
+	}
+	return nil
+}
+
+// writeDiagnosticsJSON writes the diagnostics as a json array, an empty one when there are none
+func writeDiagnosticsJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(diags)
+}
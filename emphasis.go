@@ -0,0 +1,189 @@
+package ssg
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// emphasis follows the CommonMark delimiter run rules. while the text is parsed every run of
+// `*` or `_` is kept aside as a delimiterRun, together with whether it can open or close
+// emphasis going by the characters around it. once the whole text is read the runs are
+// matched up, every closer with the closest opener before it, and turned into tags.
+// the characters of a run that are not used are written as text.
+// the extensions in extensionMap use the same runs, but only pair up with a run of the
+// same length and are used up completely
+
+type delimiterRun struct {
+	ch byte
+	// characters left in the run and how many it started with
+	count    int
+	orig     int
+	canOpen  bool
+	canClose bool
+	// closing tags written before the characters left over and opening tags after them
+	before string
+	after  string
+}
+
+// inlinePiece is either html that is done or a delimiter run still waiting for its match
+type inlinePiece struct {
+	html string
+	run  *delimiterRun
+}
+
+// inlineOut collects the output of parseInline until the emphasis is worked out
+type inlineOut struct {
+	pieces []inlinePiece
+}
+
+func (out *inlineOut) WriteString(html string) {
+	last := len(out.pieces) - 1
+	if last >= 0 && out.pieces[last].run == nil {
+		out.pieces[last].html += html
+		return
+	}
+	out.pieces = append(out.pieces, inlinePiece{html: html})
+}
+
+func (out *inlineOut) WriteByte(ch byte) error {
+	// string(ch) would make a character out of a single byte of utf-8
+	out.WriteString(string([]byte{ch}))
+	return nil
+}
+
+// runeBefore and runeAfter treat the start and the end of the text as whitespace
+func runeBefore(str string, pos int) rune {
+	if pos <= 0 {
+		return ' '
+	}
+	r, _ := utf8.DecodeLastRuneInString(str[:pos])
+	return r
+}
+
+func runeAfter(str string, pos int) rune {
+	if pos >= len(str) {
+		return ' '
+	}
+	r, _ := utf8.DecodeRuneInString(str[pos:])
+	return r
+}
+
+func isPunctRune(r rune) bool {
+	return (r < utf8.RuneSelf && isASCIIPunct(byte(r))) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// addRun adds the run of n `ch` at pos of str
+func (out *inlineOut) addRun(str string, pos int, n int) {
+	ch := str[pos]
+	before, after := runeBefore(str, pos), runeAfter(str, pos+n)
+	// a run is left flanking when it is not followed by whitespace, and when it is followed by
+	// punctuation it has to come after whitespace or punctuation. right flanking is the opposite
+	left := !unicode.IsSpace(after) && (!isPunctRune(after) || unicode.IsSpace(before) || isPunctRune(before))
+	right := !unicode.IsSpace(before) && (!isPunctRune(before) || unicode.IsSpace(after) || isPunctRune(after))
+	run := &delimiterRun{ch: ch, count: n, orig: n, canOpen: left, canClose: right}
+	if ch == '_' {
+		// `_` can not be used inside of a word, snake_case stays as it is
+		run.canOpen = left && (!right || isPunctRune(before))
+		run.canClose = right && (!left || isPunctRune(after))
+	}
+	out.pieces = append(out.pieces, inlinePiece{run: run})
+}
+
+func isExtensionRun(run *delimiterRun) bool {
+	return run.ch != '*' && run.ch != '_'
+}
+
+// String matches up the delimiter runs and returns the html
+func (out *inlineOut) String() string {
+	out.processEmphasis()
+	var html strings.Builder
+	for _, piece := range out.pieces {
+		if piece.run == nil {
+			html.WriteString(piece.html)
+			continue
+		}
+		html.WriteString(piece.run.before)
+		html.WriteString(strings.Repeat(string(piece.run.ch), piece.run.count))
+		html.WriteString(piece.run.after)
+	}
+	return html.String()
+}
+
+// processEmphasis goes through the closers from first to last and looks back for their opener
+func (out *inlineOut) processEmphasis() {
+	// openersBottom remembers where looking for an opener already failed, so that the same
+	// search is not done again for every closer. the rule of 3 makes it depend on the run
+	type openerKey struct {
+		ch       byte
+		canOpen  bool
+		origMod3 int
+	}
+	openersBottom := map[openerKey]int{}
+	for c := 0; c < len(out.pieces); c++ {
+		closer := out.pieces[c].run
+		if closer == nil || !closer.canClose || closer.count == 0 {
+			continue
+		}
+		key := openerKey{closer.ch, closer.canOpen, closer.orig % 3}
+		bottom, ok := openersBottom[key]
+		if !ok {
+			bottom = -1
+		}
+		found := -1
+		for o := c - 1; o > bottom; o-- {
+			opener := out.pieces[o].run
+			if opener == nil || !opener.canOpen || opener.count == 0 || opener.ch != closer.ch {
+				continue
+			}
+			if isExtensionRun(closer) {
+				if opener.count != closer.count {
+					continue
+				}
+				found = o
+				break
+			}
+			// a run that can both open and close can only pair up when the sum of both
+			// lengths is not a multiple of 3, unless both of them are
+			if (opener.canClose || closer.canOpen) && (opener.orig+closer.orig)%3 == 0 &&
+				!(opener.orig%3 == 0 && closer.orig%3 == 0) {
+				continue
+			}
+			found = o
+			break
+		}
+		if found < 0 {
+			openersBottom[key] = c - 1
+			if !closer.canOpen {
+				closer.canClose = false
+			}
+			continue
+		}
+
+		opener := out.pieces[found].run
+		n := 1
+		if opener.count >= 2 && closer.count >= 2 {
+			n = 2
+		}
+		tags := italicBoldMap[n-1]
+		if isExtensionRun(closer) {
+			n = closer.count
+			tags = extensionMap[strings.Repeat(string(closer.ch), n)]
+		}
+		// the tags of an earlier match are inside of this one
+		opener.after = tags[0] + opener.after
+		closer.before += tags[1]
+		opener.count -= n
+		closer.count -= n
+		// runs between the two can not be matched anymore, they are text
+		for i := found + 1; i < c; i++ {
+			if run := out.pieces[i].run; run != nil {
+				run.canOpen, run.canClose = false, false
+			}
+		}
+		if closer.count > 0 {
+			// the rest of the closer can close another opener
+			c--
+		}
+	}
+}
@@ -1,3 +1,3 @@
 #!/bin/sh
 
-go run . --src_dir=tests/src --dst_dir=tests/dest
+go run ./cmd/ssg --src_dir=tests/src --dst_dir=tests/dest
@@ -0,0 +1,422 @@
+package ssg
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// `ssg fmt` rewrites the markdown of src_dir in one style:
+//   - `#` headings with one space after the `#` characters and no closing `#`,
+//     underlined headings of a single line become `#` headings
+//   - `-` for every bullet
+//   - `*` for emphasis and `**` for strong emphasis
+//   - pipe tables padded so that their columns line up
+//   - no trailing whitespace other than line breaks, no runs of empty lines and
+//     a single newline at the end
+//   - paragraphs wrapped at fmt.wrap characters when it is set
+//
+// every rewrite is checked by converting the page before and after it, a rewrite that
+// changes the html is left out. so the formatted page converts to the same html as the
+// page did before. wrapping is the one exception, it changes where the lines of a paragraph
+// break in the html and nothing else, which is why it is off unless fmt.wrap is set. a
+// section of the config can set it for some of the files only
+
+// FormatConfig is the style of `ssg fmt`
+type FormatConfig struct {
+	// the width paragraphs are wrapped at, 0 keeps their lines as they are
+	Wrap int `json:"wrap"`
+}
+
+// formatter holds the page while the rewrites are made on it
+type formatter struct {
+	src  string
+	conf *Config
+	// the html of the page, every rewrite has to keep it
+	html string
+	// set while wrapping, the newlines outside of code are not compared
+	wrapping bool
+}
+
+func (f *formatter) convert(src string) string {
+	html := processMDConfig(src, f.conf).outStr
+	if f.wrapping {
+		// wrapping moves the soft line breaks of paragraphs around
+		html = flattenLines(html)
+	}
+	return html
+}
+
+// flattenLines replaces the newlines of the html by spaces, except the ones in <pre>
+// where they are part of the text
+func flattenLines(html string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(html, "<pre")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(html[start:], "</pre>")
+		if end < 0 {
+			break
+		}
+		end += start + len("</pre>")
+		out.WriteString(strings.ReplaceAll(html[:start], "\n", " "))
+		out.WriteString(html[start:end])
+		html = html[end:]
+	}
+	out.WriteString(strings.ReplaceAll(html, "\n", " "))
+	return out.String()
+}
+
+// apply makes the edits when the html stays the same. when it does not, the edits that
+// keep it are made one by one
+func (f *formatter) apply(edits []textEdit) {
+	if len(edits) == 0 {
+		return
+	}
+	if src := applyEdits(f.src, edits); f.convert(src) == f.html {
+		f.src = src
+		return
+	}
+	// the edits are tried from the last to the first, the positions of the ones before stay right
+	for i := len(edits) - 1; i >= 0; i-- {
+		if src := applyEdits(f.src, edits[i:i+1]); f.convert(src) == f.html {
+			f.src = src
+		}
+	}
+}
+
+// lineEdit replaces the text of the line
+func lineEdit(line lintLine, text string) textEdit {
+	return textEdit{pos: line.pos, end: line.pos + len(line.text), text: text}
+}
+
+// formatHeadings writes every heading with `#` characters
+func (f *formatter) formatHeadings() {
+	var edits []textEdit
+	lines := splitLintLines(f.src)
+	for n, line := range lines {
+		if line.code || line.blank {
+			continue
+		}
+		if level := atxLevel(line.text); level > 0 {
+			text := removeClosingHashes(strings.Trim(strings.TrimLeft(line.text, " ")[level:], " \t"))
+			heading := strings.TrimRight(strings.Repeat("#", level)+" "+text, " ")
+			if heading != line.text {
+				edits = append(edits, lineEdit(line, heading))
+			}
+			continue
+		}
+		// an underline below a paragraph of one line
+		level := isSetextUnderline(line.text)
+		if level == 0 || n == 0 || (n > 1 && !lines[n-2].blank) {
+			continue
+		}
+		prev := lines[n-1]
+		if prev.blank || prev.code || prev.directive || interruptsParagraph(prev.text) || lineIndent(prev.text) > 3 {
+			continue
+		}
+		edits = append(edits, textEdit{pos: prev.pos, end: line.pos + len(line.text),
+			text: strings.Repeat("#", level) + " " + strings.TrimSpace(prev.text)})
+	}
+	f.apply(edits)
+}
+
+// formatListMarkers writes every bullet as `-`
+func (f *formatter) formatListMarkers() {
+	var edits []textEdit
+	for _, line := range splitLintLines(f.src) {
+		if line.code || line.blank || isThematicBreak(line.text) {
+			continue
+		}
+		indent := len(line.text) - len(strings.TrimLeft(line.text, " "))
+		marker, ok := parseListMarker(line.text[indent:])
+		if ok && !marker.ordered && marker.char != '-' {
+			edits = append(edits, textEdit{pos: line.pos + indent, end: line.pos + indent + 1, text: "-"})
+		}
+	}
+	f.apply(edits)
+}
+
+// formatEmphasis writes `_text_` and `__text__` with `*`. the runs are paired up on a line,
+// the check against the html makes sure they were emphasis
+func (f *formatter) formatEmphasis() {
+	var edits []textEdit
+	for _, line := range splitLintLines(f.src) {
+		if line.code || line.blank {
+			continue
+		}
+		text := line.text
+		for i := 0; i < len(text); i++ {
+			if text[i] == '`' {
+				// code spans stay as they are
+				_, i = parseCodeSpan(text, i)
+				continue
+			}
+			if text[i] == '\\' {
+				i++
+				continue
+			}
+			n := countRun(text, i, '_')
+			if n == 0 || n > 2 || runeAfter(text, i+n) == ' ' || isWordRune(runeBefore(text, i)) {
+				i += clampFloor(n-1, 0)
+				continue
+			}
+			run := strings.Repeat("_", n)
+			end := strings.Index(text[i+n:], run)
+			if end <= 0 {
+				// the run is not closed, the runs after it can still be
+				i += n - 1
+				continue
+			}
+			end += i + n
+			if countRun(text, end, '_') != n || runeBefore(text, end) == ' ' || isWordRune(runeAfter(text, end+n)) {
+				i += n - 1
+				continue
+			}
+			stars := strings.Repeat("*", n)
+			edits = append(edits, textEdit{pos: line.pos + i, end: line.pos + i + n, text: stars},
+				textEdit{pos: line.pos + end, end: line.pos + end + n, text: stars})
+			i = end + n - 1
+		}
+	}
+	if src := applyEdits(f.src, edits); f.convert(src) == f.html {
+		f.src = src
+		return
+	}
+	// the opening and the closing run have to change together
+	for i := len(edits) - 2; i >= 0; i -= 2 {
+		f.apply(edits[i : i+2])
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r != ' ' && !isPunctRune(r)
+}
+
+// formatTables pads the cells of pipe tables to the width of their column
+func (f *formatter) formatTables() {
+	var edits []textEdit
+	lines := splitLintLines(f.src)
+	for n := 1; n < len(lines); n++ {
+		header, delimiter := lines[n-1], lines[n]
+		if header.code || delimiter.code || strings.IndexByte(header.text, '|') < 0 {
+			continue
+		}
+		align, ok := parseTableDelimiter(delimiter.text)
+		if !ok || len(splitTableRow(header.text)) != len(align) {
+			continue
+		}
+		end := n + 1
+		for end < len(lines) && !lines[end].blank && !lines[end].code && !interruptsParagraph(lines[end].text) {
+			end++
+		}
+		rows := [][]string{splitTableRow(header.text)}
+		for _, line := range lines[n+1 : end] {
+			rows = append(rows, splitTableRow(line.text))
+		}
+		widths := make([]int, len(align))
+		for i := range widths {
+			widths[i] = 3
+		}
+		for r, row := range rows {
+			for i, cell := range row {
+				row[i] = strings.ReplaceAll(cell, "|", "\\|")
+				if i < len(widths) {
+					widths[i] = max(widths[i], width(row[i]))
+				}
+			}
+			rows[r] = row
+		}
+		writeRow := func(cells []string) string {
+			out := "|"
+			for i, cell := range cells {
+				if i < len(widths) {
+					cell += strings.Repeat(" ", widths[i]-width(cell))
+				}
+				out += " " + cell + " |"
+			}
+			return out
+		}
+		delimiters := make([]string, len(align))
+		for i, a := range align {
+			dashes := strings.Repeat("-", widths[i])
+			switch a {
+			case "left":
+				dashes = ":" + dashes[1:]
+			case "right":
+				dashes = dashes[1:] + ":"
+			case "center":
+				dashes = ":" + dashes[2:] + ":"
+			}
+			delimiters[i] = dashes
+		}
+		edits = append(edits, lineEdit(header, writeRow(rows[0])), lineEdit(delimiter, writeRow(delimiters)))
+		for r, line := range lines[n+1 : end] {
+			edits = append(edits, lineEdit(line, writeRow(rows[r+1])))
+		}
+		n = end
+	}
+	f.apply(edits)
+}
+
+// formatWhitespace drops trailing whitespace that is not a line break and runs of empty lines
+func (f *formatter) formatWhitespace() {
+	var edits []textEdit
+	lines := splitLintLines(f.src)
+	for n, line := range lines {
+		if line.code {
+			continue
+		}
+		if line.blank {
+			if n == 0 || lines[n-1].blank {
+				// the empty lines after the first one go with their newline
+				edits = append(edits, textEdit{pos: line.pos, end: clampCeil(line.pos+len(line.text)+1, len(f.src)), text: ""})
+			} else if line.text != "" {
+				edits = append(edits, lineEdit(line, ""))
+			}
+			continue
+		}
+		trimmed := strings.TrimRight(line.text, " \t")
+		breaks := strings.HasSuffix(line.text, "  ") && n+1 < len(lines) && !lines[n+1].blank
+		if trimmed != line.text && !breaks {
+			edits = append(edits, lineEdit(line, trimmed))
+		}
+	}
+	f.apply(edits)
+	// one newline at the end
+	if src := strings.TrimRight(f.src, "\n") + "\n"; src != f.src && f.convert(src) == f.html {
+		f.src = src
+	}
+}
+
+// startsBlock tells if a line starting with word would be read as something else than
+// the continuation of a paragraph
+func startsBlock(word string) bool {
+	return interruptsParagraph(word+" x") || isSetextUnderline(word) > 0 || strings.HasPrefix(word, "|") ||
+		strings.HasPrefix(word, "[^") || strings.HasPrefix(word, "<")
+}
+
+// formatWrap wraps the plain paragraphs at the configured width
+func (f *formatter) formatWrap() {
+	wrap := f.conf.Fmt.Wrap
+	var edits []textEdit
+	lines := splitLintLines(f.src)
+	plain := func(line lintLine) bool {
+		text := strings.TrimRight(line.text, " ")
+		// a line of only other white space, like a no-break space, has no words to wrap
+		fields := strings.Fields(text)
+		return !line.blank && !line.code && !line.directive && lineIndent(line.text) == 0 && len(fields) > 0 &&
+			!startsBlock(fields[0]) && text == line.text && !strings.HasSuffix(text, "\\") &&
+			strings.IndexByte(text, '|') < 0
+	}
+	for n := 0; n < len(lines); n++ {
+		if !plain(lines[n]) || (n > 0 && !lines[n-1].blank) {
+			continue
+		}
+		end := n
+		for end < len(lines) && plain(lines[end]) {
+			end++
+		}
+		if end < len(lines) && !lines[end].blank && (isSetextUnderline(lines[end].text) > 0 || !interruptsParagraph(lines[end].text)) {
+			// the paragraph goes on with a line that is not plain, like a setext underline
+			n = end
+			continue
+		}
+		var words []string
+		for _, line := range lines[n:end] {
+			words = append(words, strings.Fields(line.text)...)
+		}
+		wrapped := words[0]
+		col := width(words[0])
+		for _, word := range words[1:] {
+			if col+1+width(word) > wrap && !startsBlock(word) {
+				wrapped += "\n" + word
+				col = width(word)
+				continue
+			}
+			wrapped += " " + word
+			col += 1 + width(word)
+		}
+		last := lines[end-1]
+		if src := f.src[lines[n].pos : last.pos+len(last.text)]; src != wrapped {
+			edits = append(edits, textEdit{pos: lines[n].pos, end: last.pos + len(last.text), text: wrapped})
+		}
+		n = end
+	}
+	f.apply(edits)
+}
+
+// formatMarkdown returns the page in the style of `ssg fmt`
+func formatMarkdown(src string, conf *Config) string {
+	f := formatter{src: src, conf: conf}
+	f.html = f.convert(src)
+	f.formatHeadings()
+	f.formatListMarkers()
+	f.formatEmphasis()
+	f.formatTables()
+	if conf.Fmt.Wrap > 0 {
+		// only the wrapping is compared without the newlines, the rewrites after it
+		// have to keep the html of the wrapped page exactly
+		f.wrapping = true
+		f.html = f.convert(f.src)
+		f.formatWrap()
+		f.wrapping = false
+		f.html = f.convert(f.src)
+	}
+	f.formatWhitespace()
+	return f.src
+}
+
+// fmtMain runs `ssg fmt`. it returns the exit code, with --check 1 when a file is not formatted
+func fmtMain(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	srcDirPtr := flags.String("src_dir", "", "path to blog input files")
+	confPtr := flags.String("config", "", "path to a json config file")
+	checkPtr := flags.Bool("check", false, "only list the files that are not formatted")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	conf, err := LoadConfig(*confPtr)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	files, err := markdownFiles(*srcDirPtr, &conf)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	code := 0
+	for _, rel := range files {
+		fpath := filepath.Join(*srcDirPtr, rel)
+		file_bytes, err := os.ReadFile(fpath)
+		if err != nil {
+			log.Println("Failed to read file:", fpath, ". Error:", err)
+			return 1
+		}
+		file_conf := conf.section(rel)
+		input, file := readSource(file_bytes, file_conf.Encoding)
+		formatted := formatMarkdown(input, file_conf)
+		if formatted == input {
+			continue
+		}
+		if *checkPtr {
+			fmt.Fprintln(os.Stderr, fpath, "is not formatted")
+			code = 1
+			continue
+		}
+		err = os.WriteFile(fpath, file.encode(formatted), 0666)
+		if err != nil {
+			log.Println("Failed to write file:", fpath, ". Error:", err)
+			return 1
+		}
+		fmt.Println("formatted", fpath)
+	}
+	return code
+}
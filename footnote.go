@@ -0,0 +1,243 @@
+package ssg
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// footnotes are written as references `[^label]` and definitions `[^label]: text`. the content
+// of a definition is every line indented by 4 columns after it, like the content of a list item:
+//
+//	here is a claim[^source].
+//
+//	[^source]: the first paragraph.
+//
+//	    a second paragraph of the same footnote.
+//
+// a definition can come after its references, so the references are written out as a
+// placeholder and numbered once the whole document is parsed, in the order they are used.
+// the footnotes end up in a section at the end of the article
+
+// footnoteRefPlaceholder holds the index of the reference in docState.footnoteRefs,
+// it ends with placeholderEnd. like the toc placeholder a page can not write one
+const footnoteRefPlaceholder = placeholderStart + "fnref:"
+
+type footnote struct {
+	// the converted content
+	html string
+	// where the definition is written
+	def parsedToken
+	// number of the footnote, 0 while it is not referenced
+	number int
+	refs   int
+}
+
+// normalizeLabel makes labels match without caring about case or whitespace
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// parseFootnoteLabel checks for `[^label]` at pos and returns the label and the position of the `]`
+func parseFootnoteLabel(str string, pos int) (string, int, bool) {
+	if !strings.HasPrefix(str[pos:], "[^") {
+		return "", pos, false
+	}
+	end := strings.IndexAny(str[pos+2:], "] \t\n[")
+	if end <= 0 || str[pos+2+end] != ']' {
+		return "", pos, false
+	}
+	return str[pos+2 : pos+2+end], pos + 2 + end, true
+}
+
+// parseFootnoteDef collects the content of a footnote definition into res.text and its
+// label into res.id
+func parseFootnoteDef(str string, pos int) (res parsedToken) {
+	res.pos = pos
+	line, end := lineAt(str, pos)
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	label, labelEnd, ok := parseFootnoteLabel(line, indent)
+	if lineIndent(line) > 3 || !ok || labelEnd+1 >= len(line) || line[labelEnd+1] != ':' {
+		res.statusCode = parseError
+		res.statusMessage = "not a footnote definition"
+		return res
+	}
+	res.id = label
+	res.pos = end
+	content := strings.TrimLeft(line[labelEnd+2:], " \t")
+	blanks := ""
+	for i := end + 1; i < len(str); {
+		line, end = lineAt(str, i)
+		i = end + 1
+		if isBlank(line) {
+			blanks += "\n"
+			continue
+		}
+		if lineIndent(line) >= 4 {
+			content += blanks + dedent(line, 4)
+		} else if blanks == "" && endsInParagraph(content) && !interruptsParagraph(line) {
+			content += lazyLine(line)
+		} else {
+			break
+		}
+		blanks = ""
+		res.pos = end
+	}
+	res.text = content
+	res.statusCode = parseSuccess
+	return res
+}
+
+// addFootnote converts the content of a definition and keeps it for the footnotes section
+func (state *parserState) addFootnote(def parsedToken) {
+	label := normalizeLabel(def.id)
+	// the location is looked up in the whole document, a definition can be inside of a container
+	def.pos = clampFloor(strings.Index(state.doc.src, "[^"+def.id+"]:"), 0)
+	if _, ok := state.doc.footnotes[label]; ok {
+		// reported with the rest of the footnotes, where the positions fit the input
+		def.pos = clampFloor(strings.LastIndex(state.doc.src, "[^"+def.id+"]:"), 0)
+		def.statusCode = parseWarning
+		def.statusMessage = "the footnote `" + def.id + "` is defined more than once, only the first definition is used"
+		def.rule = "footnote-duplicate"
+		state.doc.footnoteDupes = append(state.doc.footnoteDupes, def)
+		return
+	}
+	if state.doc.footnotes == nil {
+		state.doc.footnotes = map[string]*footnote{}
+	}
+	state.doc.footnotes[label] = &footnote{html: state.nested(def.text, false), def: def}
+}
+
+// parseFootnoteRef writes a placeholder for the reference `[^label]` at pos
+func (state *parserState) parseFootnoteRef(str string, pos int) (string, int, bool) {
+	label, end, ok := parseFootnoteLabel(str, pos)
+	if !ok {
+		return "", pos, false
+	}
+	var ref parsedToken
+	ref.id = label
+	// the reference is looked for after the one before it, so that each one is found at its own place
+	raw := str[pos : end+1]
+	found := strings.Index(state.doc.src[state.doc.footnoteSearch:], raw)
+	if found >= 0 {
+		ref.pos = state.doc.footnoteSearch + found
+		state.doc.footnoteSearch = ref.pos + len(raw)
+	}
+	state.doc.footnoteRefs = append(state.doc.footnoteRefs, ref)
+	return footnoteRefPlaceholder + strconv.Itoa(len(state.doc.footnoteRefs)-1) + placeholderEnd, end, true
+}
+
+// stripFootnoteRefs removes the placeholders of the references, for the text of a heading
+// that is taken before they are resolved
+func stripFootnoteRefs(html string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(html, footnoteRefPlaceholder)
+		end := strings.Index(html[max(start, 0):], placeholderEnd)
+		if start < 0 || end < 0 {
+			out.WriteString(html)
+			return out.String()
+		}
+		out.WriteString(html[:start])
+		html = html[start+end+len(placeholderEnd):]
+	}
+}
+
+// resolveFootnoteRefs swaps the placeholders of the references with links to their footnote,
+// numbering the footnotes the first time they are referenced
+func (state *parserState) resolveFootnoteRefs(html string, order *[]*footnote) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(html, footnoteRefPlaceholder)
+		if start < 0 {
+			out.WriteString(html)
+			return out.String()
+		}
+		out.WriteString(html[:start])
+		html = html[start+len(footnoteRefPlaceholder):]
+		end := strings.Index(html, placeholderEnd)
+		if end < 0 {
+			out.WriteString(footnoteRefPlaceholder)
+			continue
+		}
+		index, err := strconv.Atoi(html[:end])
+		if err != nil || index < 0 || index >= len(state.doc.footnoteRefs) {
+			out.WriteString(footnoteRefPlaceholder)
+			continue
+		}
+		html = html[end+len(placeholderEnd):]
+
+		ref := state.doc.footnoteRefs[index]
+		note, ok := state.doc.footnotes[normalizeLabel(ref.id)]
+		if !ok {
+			ref.statusCode = parseError
+			ref.statusMessage = "the footnote `" + ref.id + "` is never defined, it is written as text"
+			ref.rule = "footnote-undefined"
+			state.report(ref)
+			out.WriteString(escapeHTML("[^" + ref.id + "]"))
+			continue
+		}
+		if note.number == 0 {
+			*order = append(*order, note)
+			note.number = len(*order)
+		}
+		note.refs++
+		number := strconv.Itoa(note.number)
+		out.WriteString("<sup class=\"footnote-ref\"><a href=\"#fn-" + number + "\" id=\"" + footnoteRefID(note.number, note.refs) + "\">" + number + "</a></sup>")
+	}
+}
+
+// footnoteRefID is the id of the n-th reference to a footnote, the back links point to it
+func footnoteRefID(number int, n int) string {
+	id := "fnref-" + strconv.Itoa(number)
+	if n > 1 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+// writeFootnotes resolves the references in the article and returns the footnotes section.
+// footnotes that are never referenced are reported and left out. this runs on the state
+// of the whole document, the positions of the footnotes are positions in its input
+func (state *parserState) writeFootnotes() string {
+	var order []*footnote
+	state.outStr = state.resolveFootnoteRefs(state.outStr, &order)
+	out := ""
+	// a footnote can reference another footnote, which is then added to the end of the order
+	for i := 0; i < len(order); i++ {
+		note := order[i]
+		html := state.resolveFootnoteRefs(note.html, &order)
+		backrefs := ""
+		for n := 1; n <= note.refs; n++ {
+			backrefs += " <a href=\"#" + footnoteRefID(note.number, n) + "\" class=\"footnote-backref\">↩"
+			if n > 1 {
+				backrefs += "<sup>" + strconv.Itoa(n) + "</sup>"
+			}
+			backrefs += "</a>"
+		}
+		// the back links go at the end of the last paragraph when there is one
+		if strings.HasSuffix(html, "</p>\n") {
+			html = strings.TrimSuffix(html, "</p>\n") + backrefs + "</p>\n"
+		} else {
+			html += backrefs
+		}
+		out += "<li id=\"fn-" + strconv.Itoa(note.number) + "\">" + html + "</li>\n"
+	}
+	unused := state.doc.footnoteDupes
+	for _, note := range state.doc.footnotes {
+		if note.number == 0 {
+			note.def.statusCode = parseWarning
+			note.def.statusMessage = "the footnote `" + note.def.id + "` is never referenced, it is left out"
+			note.def.rule = "footnote-unused"
+			unused = append(unused, note.def)
+		}
+	}
+	slices.SortFunc(unused, func(a, b parsedToken) int { return a.pos - b.pos })
+	for _, def := range unused {
+		state.report(def)
+	}
+	if out == "" {
+		return ""
+	}
+	return "\n<section class=\"footnotes\">\n<ol>\n" + out + "</ol>\n</section>\n"
+}
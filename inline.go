@@ -0,0 +1,291 @@
+package ssg
+
+import (
+	"html"
+	"strings"
+)
+
+// inline parsing works on the complete text of a block (a paragraph or the text of a heading)
+// instead of a character at a time. A lot of inline elements can only be decided once their
+// closing delimiter is found, and by having the whole text we can look ahead for it and write
+// the raw characters when it is not there.
+
+func isASCIIPunct(ch byte) bool {
+	return (ch >= '!' && ch <= '/') || (ch >= ':' && ch <= '@') ||
+		(ch >= '[' && ch <= '`') || (ch >= '{' && ch <= '~')
+}
+
+func escapeHTML(str string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;").Replace(str)
+}
+
+// unescapeBackslash drops the backslash in front of ascii punctuation, for text like
+// link destinations that is not parsed for inline elements
+func unescapeBackslash(str string) string {
+	var out strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\\' && i+1 < len(str) && isASCIIPunct(str[i+1]) {
+			i++
+		}
+		out.WriteByte(str[i])
+	}
+	return out.String()
+}
+
+// parseEntity keeps an entity reference like `&copy;`, `&#123;` or `&#x1F600;` as it is,
+// any other `&` is escaped. returns the html and the position of the last character consumed
+func parseEntity(str string, pos int) (string, int) {
+	end := strings.IndexByte(str[pos:], ';')
+	if end < 2 || end > 33 {
+		return "&amp;", pos
+	}
+	name := str[pos+1 : pos+end]
+	valid := false
+	switch {
+	case name[0] == '#' && len(name) > 2 && (name[1] == 'x' || name[1] == 'X'):
+		valid = len(name) <= 8 && strings.Trim(name[2:], "0123456789abcdefABCDEF") == ""
+	case name[0] == '#':
+		valid = len(name) <= 8 && strings.Trim(name[1:], "0123456789") == ""
+	default:
+		// a named entity is only valid when html knows it
+		entity := str[pos : pos+end+1]
+		valid = strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") == "" &&
+			html.UnescapeString(entity) != entity
+	}
+	if !valid {
+		return "&amp;", pos
+	}
+	return str[pos : pos+end+1], pos + end
+}
+
+// countRun returns how many times ch repeats starting at pos
+func countRun(str string, pos int, ch byte) int {
+	n := 0
+	for pos+n < len(str) && str[pos+n] == ch {
+		n++
+	}
+	return n
+}
+
+// parseInline converts the inline markdown of a paragraph or heading into html
+func (state *parserState) parseInline(str string) string {
+	var out inlineOut
+	for i := 0; i < len(str); i++ {
+		ch := str[i]
+		switch ch {
+		case '\\':
+			if i+2 < len(str) && str[i+1] == '\n' {
+				// a backslash at the end of a line is a line break, at the end of the text it is a backslash
+				out.WriteString("<br />")
+				break
+			}
+			if i+1 < len(str) && isASCIIPunct(str[i+1]) {
+				// escaped characters are written as text and are never treated as markdown
+				i++
+			}
+			out.WriteString(escapeHTML(str[i : i+1]))
+		case '`':
+			html, end := parseCodeSpan(str, i)
+			out.WriteString(html)
+			i = end
+		case '*', '_':
+			// emphasis is only known once the whole text is read, see emphasis.go
+			n := countRun(str, i, ch)
+			out.addRun(str, i, n)
+			i += n - 1
+		case '~', '=', '^', '+':
+			n := countRun(str, i, ch)
+			if state.conf.Extensions.enabled(str[i : i+n]) {
+				out.addRun(str, i, n)
+			} else {
+				out.WriteString(str[i : i+n])
+			}
+			i += n - 1
+		case '[':
+			if html, end, ok := state.parseFootnoteRef(str, i); ok {
+				out.WriteString(html)
+				i = end
+				break
+			}
+			html, end := state.parseLink(str, i)
+			out.WriteString(html)
+			i = end
+		case '&':
+			html, end := parseEntity(str, i)
+			out.WriteString(html)
+			i = end
+		case '<':
+			if html, end, ok := parseAutolink(str, i); ok && !state.inLink {
+				out.WriteString(html)
+				i = end
+				break
+			}
+			end, ok := parseInlineHTML(str, i)
+			if !ok {
+				out.WriteString("&lt;")
+				break
+			}
+			out.WriteString(state.writeRawHTML(str[i : end+1]))
+			i = end
+		case '>', '"':
+			out.WriteString(escapeHTML(str[i : i+1]))
+		case '{':
+			html, end := state.parseInlineComponent(str, i)
+			out.WriteString(html)
+			i = end
+		case ' ':
+			// two or more spaces at the end of a line turn into a line break
+			n := countRun(str, i, ' ')
+			if n >= 2 && (i+n == len(str) || str[i+n] == '\n') {
+				out.WriteString(" <br />")
+			} else {
+				out.WriteString(str[i : i+n])
+			}
+			i += n - 1
+		case 'h', 'w':
+			if state.conf.Extensions.Linkify && !state.inLink {
+				if html, end, ok := parseBareURL(str, i); ok {
+					out.WriteString(html)
+					i = end
+					break
+				}
+			}
+			out.WriteByte(ch)
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	return out.String()
+}
+
+// parseCodeSpan expects the backtick run at pos to be closed by a run of the same length.
+// returns the html and the position of the last character consumed
+func parseCodeSpan(str string, pos int) (string, int) {
+	n := countRun(str, pos, '`')
+	for i := pos + n; i < len(str); i++ {
+		if str[i] != '`' {
+			continue
+		}
+		m := countRun(str, i, '`')
+		if m == n {
+			code := strings.ReplaceAll(str[pos+n:i], "\n", " ")
+			// a single space on both ends is there to allow code starting or ending with a backtick
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			return "<code>" + escapeHTML(code) + "</code>", i + m - 1
+		}
+		i += m - 1
+	}
+	// not closed, the backticks are plain text
+	return str[pos : pos+n], pos + n - 1
+}
+
+// parseLink handles `[text](destination "title")`. anything that does not fit
+// is written back as plain text starting with the `[`
+func (state *parserState) parseLink(str string, pos int) (string, int) {
+	depth := 0
+	textEnd := -1
+	for i := pos; i < len(str) && textEnd < 0; i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '`':
+			_, i = parseCodeSpan(str, i)
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				textEnd = i
+			}
+		}
+	}
+	if textEnd < 0 || textEnd+1 >= len(str) || str[textEnd+1] != '(' {
+		return "[", pos
+	}
+
+	i := textEnd + 2
+	for i < len(str) && (str[i] == ' ' || str[i] == '\n') {
+		i++
+	}
+	dest := ""
+	if i < len(str) && str[i] == '<' {
+		end := strings.IndexAny(str[i:], ">\n")
+		if end < 0 || str[i+end] != '>' {
+			return "[", pos
+		}
+		dest = str[i+1 : i+end]
+		i += end + 1
+	} else {
+		start := i
+		parens := 0
+		for ; i < len(str) && str[i] != ' ' && str[i] != '\n'; i++ {
+			if str[i] == '\\' && i+1 < len(str) {
+				i++
+			} else if str[i] == '(' {
+				parens++
+			} else if str[i] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = str[start:i]
+	}
+	for i < len(str) && (str[i] == ' ' || str[i] == '\n') {
+		i++
+	}
+	title := ""
+	if i < len(str) && (str[i] == '"' || str[i] == '\'') {
+		end := i + 1
+		for end < len(str) && str[end] != str[i] {
+			if str[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(str) {
+			return "[", pos
+		}
+		end -= i + 1
+		title = str[i+1 : i+1+end]
+		i += end + 2
+		for i < len(str) && (str[i] == ' ' || str[i] == '\n') {
+			i++
+		}
+	}
+	if i >= len(str) || str[i] != ')' {
+		return "[", pos
+	}
+	state.addLink(str[pos:i+1], unescapeBackslash(dest))
+
+	html := "<a href=\"" + escapeHTML(unescapeBackslash(dest)) + "\""
+	if title != "" {
+		html += " title=\"" + escapeHTML(unescapeBackslash(title)) + "\""
+	}
+	// links can not be inside of the text of a link
+	inLink := state.inLink
+	state.inLink = true
+	html += ">" + state.parseInline(str[pos+1:textEnd]) + "</a>"
+	state.inLink = inLink
+	return html, i
+}
+
+// stripTags drops every html tag and keeps the text in between
+func stripTags(html string) string {
+	var out strings.Builder
+	inTag := false
+	for i := 0; i < len(html); i++ {
+		switch {
+		case html[i] == '<':
+			inTag = true
+		case html[i] == '>' && inTag:
+			inTag = false
+		case !inTag:
+			out.WriteByte(html[i])
+		}
+	}
+	return out.String()
+}
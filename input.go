@@ -0,0 +1,180 @@
+package ssg
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// the parser only works with utf-8 and `\n` line endings. files are brought into that shape
+// before they get to processMD: the encoding is decoded, a byte order mark is dropped,
+// `\r\n` and `\r` become `\n` and the tabs in the indentation of a line are expanded to spaces.
+// files without a byte order mark that are not valid utf-8 are taken to be latin-1, the
+// encoding config can name the encoding of a directory or a file instead
+
+const (
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingLatin1  = "latin-1"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+func isEncoding(encoding string) bool {
+	return containsString([]string{EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingLatin1}, encoding)
+}
+
+// detectEncoding looks at the byte order mark and checks if the rest is utf-8
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return EncodingUTF8
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return EncodingUTF16BE
+	case utf8.Valid(data):
+		return EncodingUTF8
+	}
+	return EncodingLatin1
+}
+
+// decodeInput turns the file into utf-8, with auto the encoding is detected.
+// returns the text and the encoding it was read as
+func decodeInput(data []byte, encoding string) (string, string) {
+	if encoding == "" || encoding == EncodingAuto {
+		encoding = detectEncoding(data)
+	}
+	switch encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			if encoding == EncodingUTF16LE {
+				units = append(units, uint16(data[i])|uint16(data[i+1])<<8)
+			} else {
+				units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+			}
+		}
+		return string(utf16.Decode(units)), encoding
+	case EncodingLatin1:
+		// every byte is the code point of the same number
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), encoding
+	}
+	// bytes that are not utf-8 become U+FFFD instead of breaking the parser
+	return strings.ToValidUTF8(string(bytes.TrimPrefix(data, bomUTF8)), "\ufffd"), EncodingUTF8
+}
+
+// expandTabs replaces the tabs in the indentation of a line, the spaces and `>` of
+// blockquotes it starts with, by spaces up to the next tab stop of 4 columns.
+// tabs after the indentation are content, like the ones in a code block, and are kept
+func expandTabs(line string) string {
+	if strings.IndexByte(line, '\t') < 0 {
+		return line
+	}
+	var out strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\t':
+			next := col + 4 - col%4
+			out.WriteString(strings.Repeat(" ", next-col))
+			col = next
+		case ' ', '>':
+			out.WriteByte(line[i])
+			col++
+		default:
+			out.WriteString(line[i:])
+			return out.String()
+		}
+	}
+	return out.String()
+}
+
+// normalizeInput gives the text `\n` line endings and expands the tabs of the indentation
+func normalizeInput(str string) string {
+	str = strings.ReplaceAll(str, "\r\n", "\n")
+	str = strings.ReplaceAll(str, "\r", "\n")
+	lines := strings.Split(str, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readInput is what happens to a markdown file before it is converted
+func readInput(data []byte, encoding string) (string, string) {
+	str, encoding := decodeInput(data, encoding)
+	// the byte order mark of utf-16 is decoded with the text
+	str = strings.TrimPrefix(str, "\ufeff")
+	return normalizeInput(str), encoding
+}
+
+// sourceFile is how a markdown file was stored, so a tool that changes the file
+// can write it back the same way
+type sourceFile struct {
+	encoding string
+	bom      bool
+	newline  string
+}
+
+// readSource decodes the file for a tool that writes it back. unlike readInput the
+// tabs are kept, only the line endings become `\n`
+func readSource(data []byte, encoding string) (string, sourceFile) {
+	str, encoding := decodeInput(data, encoding)
+	file := sourceFile{encoding: encoding, newline: "\n"}
+	file.bom = bytes.HasPrefix(data, bomUTF8) || strings.HasPrefix(str, "\ufeff")
+	str = strings.TrimPrefix(str, "\ufeff")
+	if strings.Contains(str, "\r\n") {
+		file.newline = "\r\n"
+	} else if strings.Contains(str, "\r") {
+		file.newline = "\r"
+	}
+	str = strings.ReplaceAll(str, "\r\n", "\n")
+	str = strings.ReplaceAll(str, "\r", "\n")
+	return str, file
+}
+
+// encode turns the text back into the bytes of the file, with its line endings,
+// byte order mark and encoding. latin-1 can not store every character, those become `?`
+func (file sourceFile) encode(text string) []byte {
+	if file.newline != "\n" {
+		text = strings.ReplaceAll(text, "\n", file.newline)
+	}
+	if file.bom {
+		text = "\ufeff" + text
+	}
+	switch file.encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		units := utf16.Encode([]rune(text))
+		data := make([]byte, 0, len(units)*2)
+		for _, unit := range units {
+			if file.encoding == EncodingUTF16LE {
+				data = append(data, byte(unit), byte(unit>>8))
+			} else {
+				data = append(data, byte(unit>>8), byte(unit))
+			}
+		}
+		return data
+	case EncodingLatin1:
+		data := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > 0xFF {
+				r = '?'
+			}
+			data = append(data, byte(r))
+		}
+		return data
+	}
+	return []byte(text)
+}
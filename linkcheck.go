@@ -0,0 +1,88 @@
+package ssg
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the link checker looks at the destinations of the links of a page once it is converted.
+// a relative link has to point to a file in the source directory, `.html` links can point to
+// the page a `.md` file turns into, and a link to `#id` needs a heading with that id on the
+// page. links with a scheme and links from the root of the site (`/...`) are not checked
+
+// addLink keeps the destination of the link written as raw for the link checker
+func (state *parserState) addLink(raw string, dest string) {
+	var link parsedToken
+	link.text = dest
+	link.str = dest
+	link.pos = state.doc.linkSearch
+	found := strings.Index(state.doc.src[state.doc.linkSearch:], raw)
+	if found >= 0 {
+		link.pos = state.doc.linkSearch + found + strings.LastIndex(raw, "](") + 2
+		// the text of the link is parsed after it, a link in there is found from here
+		state.doc.linkSearch += found + 1
+	}
+	state.doc.links = append(state.doc.links, link)
+}
+
+// isExternalURL tells if the url has a scheme, like `https:` or `mailto:`, or a host
+func isExternalURL(dest string) bool {
+	colon := strings.IndexByte(dest, ':')
+	return strings.HasPrefix(dest, "//") || (colon > 0 && !strings.ContainsAny(dest[:colon], "/?#"))
+}
+
+// linkTargetExists checks for the file, a page is there when its markdown is
+func linkTargetExists(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	if strings.HasSuffix(path, ".html") {
+		_, err := os.Stat(strings.TrimSuffix(path, ".html") + ".md")
+		return err == nil
+	}
+	return false
+}
+
+// hasHeadingID tells if a heading of the page has the id
+func (state *parserState) hasHeadingID(id string) bool {
+	for _, heading := range state.doc.headings {
+		if heading.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLinks reports the links of the page that lead nowhere. path is the markdown file,
+// relative links start from its directory
+func (state *parserState) checkLinks(path string) {
+	for _, link := range state.doc.links {
+		if link.text == "" || isExternalURL(link.text) {
+			continue
+		}
+		link.statusCode = parseWarning
+		target, fragment, _ := strings.Cut(link.text, "#")
+		target, _, _ = strings.Cut(target, "?")
+		if target == "" {
+			if fragment != "" && !state.hasHeadingID(fragment) {
+				link.statusMessage = "the link points to `#" + fragment + "`, no heading on this page has that id"
+				link.rule = "link-anchor"
+				state.report(link)
+			}
+			continue
+		}
+		if strings.HasPrefix(target, "/") {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		if !linkTargetExists(filepath.Join(filepath.Dir(path), filepath.FromSlash(target))) {
+			link.statusMessage = "the link points to `" + link.text + "`, there is no such file"
+			link.rule = "link-broken"
+			state.report(link)
+		}
+	}
+}
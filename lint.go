@@ -0,0 +1,437 @@
+package ssg
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// `ssg lint` checks the markdown of src_dir against style rules. the markdown is still
+// valid when a rule is broken, the rules catch what is easy to get wrong or hard to read:
+//
+//	heading-increment     a heading more than one level below the heading before it
+//	single-h1             more than one h1 on a page
+//	trailing-space-break  a line break made with two trailing spaces, that can not be seen (fixable)
+//	list-marker-style     bullets that are not the bullet the page starts with (fixable)
+//	line-length           lines longer than lint.line_length that can be wrapped
+//	emphasis-as-heading   a paragraph of only bold or italic text, standing in for a heading
+//
+// every rule is on unless the config turns it off. inside of a page the comments
+// `<!-- lint-disable rule -->`, `<!-- lint-enable rule -->` and `<!-- lint-disable-next-line rule -->`
+// turn rules off and on again, without rule names they apply to all rules.
+// the linter works on the lines of the page, code blocks are skipped. a fix is only made
+// when the page still converts to the same html
+
+const (
+	lintHeadingIncrement   = "heading-increment"
+	lintSingleH1           = "single-h1"
+	lintTrailingSpaceBreak = "trailing-space-break"
+	lintListMarkerStyle    = "list-marker-style"
+	lintLineLength         = "line-length"
+	lintEmphasisAsHeading  = "emphasis-as-heading"
+)
+
+var lintRules = []string{
+	lintHeadingIncrement, lintSingleH1, lintTrailingSpaceBreak, lintListMarkerStyle, lintLineLength, lintEmphasisAsHeading,
+}
+
+// LintConfig turns lint rules on and off
+type LintConfig struct {
+	// rules set to false are off, the rules that are not listed are on
+	Rules map[string]bool `json:"rules"`
+	// the longest line the line-length rule allows, in characters
+	LineLength int `json:"line_length"`
+}
+
+func (conf LintConfig) enabled(rule string) bool {
+	on, ok := conf.Rules[rule]
+	return !ok || on
+}
+
+type lintLine struct {
+	text string
+	// where the line starts in the page
+	pos   int
+	blank bool
+	code  bool
+	// a `<!-- lint-... -->` comment
+	directive bool
+}
+
+type lintDirective struct {
+	line  int
+	kind  string
+	rules []string
+}
+
+// textEdit replaces src[pos:end] with text, the lint fixes and the formatter are made of them
+type textEdit struct {
+	pos  int
+	end  int
+	text string
+}
+
+type linter struct {
+	state      *parserState
+	conf       LintConfig
+	lines      []lintLine
+	directives []lintDirective
+	fix        bool
+	edits      []textEdit
+	// the page and its html, a fix has to keep the html
+	src    string
+	config *Config
+	html   string
+}
+
+// parseLintDirective checks for a `<!-- lint-disable rule ... -->` comment on the line
+func parseLintDirective(line string) (lintDirective, bool) {
+	var directive lintDirective
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "<!--") || !strings.HasSuffix(line, "-->") {
+		return directive, false
+	}
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(line, "<!--"), "-->"))
+	if len(fields) == 0 {
+		return directive, false
+	}
+	switch fields[0] {
+	case "lint-disable", "lint-enable", "lint-disable-next-line":
+		directive.kind = fields[0]
+		directive.rules = fields[1:]
+		return directive, true
+	}
+	return directive, false
+}
+
+// allowed tells if the comments before line n leave the rule on for it
+func (l *linter) allowed(rule string, n int) bool {
+	on := true
+	for _, directive := range l.directives {
+		if directive.line >= n {
+			break
+		}
+		if len(directive.rules) > 0 && !slices.Contains(directive.rules, rule) {
+			continue
+		}
+		switch directive.kind {
+		case "lint-disable":
+			on = false
+		case "lint-enable":
+			on = true
+		case "lint-disable-next-line":
+			if directive.line == n-1 {
+				return false
+			}
+		}
+	}
+	return on
+}
+
+// keepsHTML tells if the page converts to the same html with the edit, made after the
+// edits of the fixes before it. a fix that changes the html, like a bullet that would join
+// two lists into one, is not made
+func (l *linter) keepsHTML(edit textEdit) bool {
+	if l.html == "" {
+		l.html = l.convert(l.src)
+	}
+	edits := []textEdit{edit}
+	if l.fix {
+		edits = append(slices.Clone(l.edits), edit)
+	}
+	return l.convert(applyEdits(l.src, edits)) == l.html
+}
+
+// convert gives the html of the page to compare. the space kept before a line break made
+// with spaces does not show, so a `\` break is the same
+func (l *linter) convert(src string) string {
+	return strings.ReplaceAll(processMDConfig(src, l.config).outStr, " <br />", "<br />")
+}
+
+// report adds a problem at offset in line n, marking the text mark. a problem with an edit
+// is fixed instead of reported when fixing
+func (l *linter) report(rule string, n int, offset int, mark string, message string, edit *textEdit) {
+	if !l.conf.enabled(rule) || !l.allowed(rule, n) {
+		return
+	}
+	if edit != nil && l.keepsHTML(*edit) {
+		if l.fix {
+			l.edits = append(l.edits, *edit)
+			return
+		}
+		message += ", `ssg lint --fix` fixes it"
+	}
+	var info parsedToken
+	info.pos = l.lines[n].pos + offset
+	info.str = mark
+	info.statusCode = parseWarning
+	info.statusMessage = message
+	info.rule = rule
+	l.state.report(info)
+}
+
+// splitLintLines finds the lines of the page that are code, where no rule applies.
+// html blocks are skipped like code
+func splitLintLines(src string) []lintLine {
+	var lines []lintLine
+	var fenceCh byte
+	fenceN, htmlKind := 0, 0
+	inFence, inList := false, false
+	for pos := 0; pos < len(src); {
+		text, end := lineAt(src, pos)
+		line := lintLine{text: strings.TrimSuffix(text, "\n"), pos: pos, blank: isBlank(text)}
+		pos = end + 1
+		var prev lintLine
+		if len(lines) > 0 {
+			prev = lines[len(lines)-1]
+		}
+		// code blocks inside of list items are indented with the item
+		fenceLine := line.text
+		if inList {
+			fenceLine = strings.TrimLeft(fenceLine, " ")
+		}
+		switch {
+		case inFence:
+			line.code = true
+			inFence = !closesFence(fenceLine, fenceCh, fenceN)
+		case htmlKind > 0 && (htmlKind < 6 || !line.blank):
+			line.code = true
+			if htmlKind < 6 && htmlBlockEnds(line.text, htmlKind) {
+				htmlKind = 0
+			}
+		case !line.blank && lineIndent(line.text) >= 4 && !inList && (len(lines) == 0 || prev.blank || prev.code):
+			line.code = true
+		default:
+			htmlKind = 0
+			if ch, n, _, ok := parseFence(fenceLine); ok {
+				line.code = true
+				inFence, fenceCh, fenceN = true, ch, n
+				break
+			}
+			_, line.directive = parseLintDirective(line.text)
+			if kind := htmlBlockStart(fenceLine, len(lines) > 0 && !prev.blank && !prev.code); kind > 0 && !line.directive {
+				line.code = true
+				if kind >= 6 || !htmlBlockEnds(line.text, kind) {
+					htmlKind = kind
+				}
+				break
+			}
+			if _, ok := parseListMarker(strings.TrimLeft(line.text, " ")); ok && !isThematicBreak(line.text) {
+				inList = true
+			} else if !line.blank && lineIndent(line.text) == 0 && prev.blank {
+				inList = false
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// continuesParagraph tells if line is paragraph text and next is more of the same paragraph,
+// only then do two spaces at the end of line make a line break
+func continuesParagraph(line string, next string) bool {
+	// the markers of the blockquotes both lines are in
+	for {
+		quoted, nextQuoted := strings.TrimLeft(line, " "), strings.TrimLeft(next, " ")
+		if !strings.HasPrefix(quoted, ">") || !strings.HasPrefix(nextQuoted, ">") {
+			break
+		}
+		line = strings.TrimPrefix(quoted[1:], " ")
+		next = strings.TrimPrefix(nextQuoted[1:], " ")
+	}
+	first := strings.TrimLeft(line, " \t")
+	if isBlank(line) || strings.HasPrefix(first, "|") || atxLevel(line) > 0 || isThematicBreak(line) {
+		return false
+	}
+	return !isBlank(next) && isSetextUnderline(next) == 0 && !interruptsParagraph(next)
+}
+
+// atxLevel returns the level of a `#` heading, 0 when the line is not one
+func atxLevel(line string) int {
+	if lineIndent(line) > 3 {
+		return 0
+	}
+	line = strings.TrimLeft(line, " ")
+	n := countRun(line, 0, '#')
+	if n == 0 || n > len(hMap) || (n < len(line) && line[n] != ' ' && line[n] != '\t') {
+		return 0
+	}
+	return n
+}
+
+// isEmphasisLine checks if the whole line is one run of bold or italic text without
+// punctuation at its end, the way a heading would be written
+func isEmphasisLine(line string) bool {
+	line = strings.TrimSpace(line)
+	for _, delim := range []string{"**", "__", "*", "_"} {
+		inner := strings.TrimSuffix(strings.TrimPrefix(line, delim), delim)
+		if len(inner)+2*len(delim) != len(line) || inner == "" {
+			continue
+		}
+		if strings.Contains(inner, delim) || inner != strings.TrimSpace(inner) || strings.ContainsAny(inner[len(inner)-1:], ".!?:,;") {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// lintMarkdown checks the page against the rules, the problems end up in the diagnostics
+// of the returned state. when fixing, the problems that can be fixed are not reported and
+// the fixed page is returned
+func lintMarkdown(src string, conf *Config, fix bool) (parserState, string) {
+	state := parserState{inpStr: src, conf: conf, doc: &docState{src: src}}
+	l := linter{state: &state, conf: conf.Lint, fix: fix, lines: splitLintLines(src), src: src, config: conf}
+	for n, line := range l.lines {
+		if directive, ok := parseLintDirective(line.text); ok && line.directive {
+			directive.line = n
+			l.directives = append(l.directives, directive)
+		}
+	}
+
+	prevLevel, h1s := 0, 0
+	var bullet byte
+	for n, line := range l.lines {
+		if line.blank || line.code || line.directive {
+			continue
+		}
+		next := lintLine{blank: true}
+		if n+1 < len(l.lines) {
+			next = l.lines[n+1]
+		}
+		prev := lintLine{blank: true}
+		if n > 0 {
+			prev = l.lines[n-1]
+		}
+
+		level := atxLevel(line.text)
+		heading := n
+		if level == 0 && !prev.blank && !prev.code && atxLevel(prev.text) == 0 && !isThematicBreak(prev.text) {
+			if _, ok := parseListMarker(prev.text); !ok {
+				level = isSetextUnderline(line.text)
+				heading = n - 1
+			}
+		}
+		if level > 0 {
+			text := strings.TrimSpace(l.lines[heading].text)
+			offset := strings.Index(l.lines[heading].text, text)
+			if level == 1 {
+				h1s++
+				if h1s > 1 {
+					l.report(lintSingleH1, heading, offset, text, "the page already has an h1, use one h1 for the title of the page", nil)
+				}
+			}
+			if prevLevel > 0 && level > prevLevel+1 {
+				l.report(lintHeadingIncrement, heading, offset, text,
+					"the heading goes from h"+strconv.Itoa(prevLevel)+" to h"+strconv.Itoa(level)+", headings should only go down one level at a time", nil)
+			}
+			prevLevel = level
+		}
+
+		indent := len(line.text) - len(strings.TrimLeft(line.text, " \t"))
+		if marker, ok := parseListMarker(line.text[indent:]); ok && !marker.ordered && !isThematicBreak(line.text) {
+			if bullet == 0 {
+				bullet = marker.char
+			} else if marker.char != bullet {
+				pos := line.pos + indent
+				l.report(lintListMarkerStyle, n, indent, string(marker.char),
+					"the list item uses `"+string(marker.char)+"`, the page uses `"+string(bullet)+"` for its lists",
+					&textEdit{pos: pos, end: pos + 1, text: string(bullet)})
+			}
+		}
+
+		trimmed := strings.TrimRight(line.text, " ")
+		if level == 0 && len(line.text)-len(trimmed) >= 2 && !next.code && !next.directive && continuesParagraph(line.text, next.text) {
+			pos := line.pos + len(trimmed)
+			l.report(lintTrailingSpaceBreak, n, len(trimmed), line.text[len(trimmed):],
+				"the two spaces at the end of the line make a line break that can not be seen, use a `\\` instead",
+				&textEdit{pos: pos, end: line.pos + len(line.text), text: "\\"})
+		}
+
+		chars := graphemes(line.text)
+		first := strings.TrimLeft(line.text, " ")
+		if len(chars) > l.conf.LineLength && !strings.HasPrefix(first, "|") && !strings.HasPrefix(first, "+") &&
+			strings.ContainsAny(strings.Join(chars[l.conf.LineLength:], ""), " \t") {
+			offset := len(strings.Join(chars[:l.conf.LineLength], ""))
+			l.report(lintLineLength, n, offset, line.text[offset:],
+				"the line is "+strconv.Itoa(len(chars))+" characters long, wrap it at "+strconv.Itoa(l.conf.LineLength), nil)
+		}
+
+		if prev.blank && next.blank && isEmphasisLine(line.text) {
+			text := strings.TrimSpace(line.text)
+			l.report(lintEmphasisAsHeading, n, strings.Index(line.text, text), text,
+				"the paragraph is only emphasis, use a heading if it is one", nil)
+		}
+	}
+	return state, applyEdits(src, l.edits)
+}
+
+// applyEdits makes the edits from the last to the first, so that the positions stay right
+func applyEdits(src string, edits []textEdit) string {
+	edits = slices.Clone(edits)
+	slices.SortFunc(edits, func(a, b textEdit) int { return b.pos - a.pos })
+	for _, edit := range edits {
+		src = src[:edit.pos] + edit.text + src[edit.end:]
+	}
+	return src
+}
+
+// lintMain runs `ssg lint`. it returns the exit code, 1 when a problem is left
+func lintMain(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	srcDirPtr := flags.String("src_dir", "", "path to blog input files")
+	confPtr := flags.String("config", "", "path to a json config file")
+	fixPtr := flags.Bool("fix", false, "fix the problems that can be fixed in the files")
+	formatPtr := flags.String("diagnostics-format", diagnosticsText, "how problems are written: text, json or sarif")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := checkDiagnosticsFormat(*formatPtr); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	conf, err := LoadConfig(*confPtr)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	files, err := markdownFiles(*srcDirPtr, &conf)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	var diags []Diagnostic
+	for _, rel := range files {
+		fpath := filepath.Join(*srcDirPtr, rel)
+		file_bytes, err := os.ReadFile(fpath)
+		if err != nil {
+			log.Println("Failed to read file:", fpath, ". Error:", err)
+			return 1
+		}
+		file_conf := conf.section(rel)
+		input, file := readSource(file_bytes, file_conf.Encoding)
+		state, fixed := lintMarkdown(input, file_conf, *fixPtr)
+		if fixed != input {
+			err = os.WriteFile(fpath, file.encode(fixed), 0666)
+			if err != nil {
+				log.Println("Failed to write file:", fpath, ". Error:", err)
+				return 1
+			}
+		}
+		diags = append(diags, state.diagnostics(fpath)...)
+	}
+	err = printDiagnostics(diags, *formatPtr)
+	if err != nil {
+		log.Println("Failed to write diagnostics. Error:", err)
+		return 1
+	}
+	if len(diags) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,557 @@
+package ssg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// `ssg lsp` is a language server for editors, it speaks LSP over stdin and stdout.
+// it publishes the diagnostics of a page while it is edited, lists its headings as symbols,
+// goes to the definition of footnotes, reference links and links to other pages, and completes
+// the destinations of links with the files of src_dir and the ids of the headings of the page.
+// reference links (`[text][label]` with a `[label]: url` line) are only looked up, the converter
+// does not turn them into links
+
+// lspMessage is a request, a response or a notification of json-rpc
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  any              `json:"result"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// positions count lines from 0 and characters in utf-16 code units, the default of LSP
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspSymbol struct {
+	Name           string      `json:"name"`
+	Kind           int         `json:"kind"`
+	Range          lspRange    `json:"range"`
+	SelectionRange lspRange    `json:"selectionRange"`
+	Children       []lspSymbol `json:"children"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspCompletion struct {
+	Label    string      `json:"label"`
+	Kind     int         `json:"kind"`
+	TextEdit lspTextEdit `json:"textEdit"`
+}
+
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspSeverityError  = 1
+	lspSeverityWarn   = 2
+	lspSymbolString   = 15
+	lspCompletionFile = 17
+	lspCompletionRef  = 18
+)
+
+// toLSPPosition turns a byte position of src into a line and a utf-16 character
+func toLSPPosition(src string, pos int) lspPosition {
+	pos = clampCeil(clampFloor(pos, 0), len(src))
+	lineStart := strings.LastIndexByte(src[:pos], '\n') + 1
+	character := 0
+	for _, r := range src[lineStart:pos] {
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return lspPosition{Line: strings.Count(src[:pos], "\n"), Character: character}
+}
+
+// fromLSPPosition turns a line and a utf-16 character into a byte position of src
+func fromLSPPosition(src string, position lspPosition) int {
+	pos := 0
+	for line := 0; line < position.Line; line++ {
+		next := strings.IndexByte(src[pos:], '\n')
+		if next < 0 {
+			return len(src)
+		}
+		pos += next + 1
+	}
+	character := 0
+	for i, r := range src[pos:] {
+		if r == '\n' || character >= position.Character {
+			return pos + i
+		}
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return len(src)
+}
+
+func toLSPRange(src string, pos int, end int) lspRange {
+	return lspRange{Start: toLSPPosition(src, pos), End: toLSPPosition(src, end)}
+}
+
+func uriToPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(parsed.Path)
+}
+
+func pathToURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+type lspServer struct {
+	in     *bufio.Reader
+	out    io.Writer
+	srcDir string
+	conf   *Config
+	// the text of the open documents by their uri
+	docs     map[string]string
+	shutdown bool
+}
+
+// read reads the next message, they come after a `Content-Length` header
+// errLSPParse is returned by read for a message that can not be read, the server answers
+// it with a parse error and goes on with the next message
+var errLSPParse = errors.New("invalid message")
+
+func (s *lspServer) read() (lspMessage, error) {
+	var msg lspMessage
+	length := -1
+	for {
+		header, err := s.in.ReadString('\n')
+		if err != nil {
+			return msg, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(header, "Content-Length:"); ok {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				length = -1
+			}
+		}
+	}
+	if length < 0 {
+		// without the length there is no telling where the body ends, it is read as headers
+		return msg, fmt.Errorf("%w: no Content-Length header", errLSPParse)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, fmt.Errorf("%w: %w", errLSPParse, err)
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg any) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Failed to encode message. Error:", err)
+		return
+	}
+	io.WriteString(s.out, "Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n")
+	s.out.Write(body)
+}
+
+func (s *lspServer) notify(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// config returns the config for the document at path, going by its place in src_dir
+func (s *lspServer) config(path string) *Config {
+	rel, err := filepath.Rel(s.srcDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return s.conf
+	}
+	return s.conf.section(rel)
+}
+
+// text returns the text of the document, from the editor when it is open and from the file otherwise
+func (s *lspServer) text(uri string) (string, bool) {
+	if text, ok := s.docs[uri]; ok {
+		return text, true
+	}
+	file_bytes, err := os.ReadFile(uriToPath(uri))
+	if err != nil {
+		return "", false
+	}
+	input, _ := readInput(file_bytes, s.config(uriToPath(uri)).Encoding)
+	return input, true
+}
+
+// convert converts the document like a build would
+func (s *lspServer) convert(uri string, text string) parserState {
+	path := uriToPath(uri)
+	conf := s.config(path)
+	state := processMDConfig(text, conf)
+	if conf.CheckLinks && path != "" {
+		state.checkLinks(path)
+	}
+	return state
+}
+
+func (s *lspServer) publishDiagnostics(uri string) {
+	diags := []lspDiagnostic{}
+	if text, ok := s.docs[uri]; ok {
+		state := s.convert(uri, text)
+		for _, diag := range state.diagnostics(uriToPath(uri)) {
+			severity := lspSeverityError
+			if diag.Severity == SeverityWarning {
+				severity = lspSeverityWarn
+			}
+			diags = append(diags, lspDiagnostic{Range: toLSPRange(text, diag.pos, diag.end), Severity: severity,
+				Code: diag.Rule, Source: "ssg", Message: diag.Message})
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diags})
+}
+
+// symbols lists the headings of the document, the headings below a heading are its children
+func (s *lspServer) symbols(uri string, text string) []lspSymbol {
+	headings := s.convert(uri, text).doc.headings
+	var build func(i int, level int) ([]lspSymbol, int)
+	build = func(i int, level int) ([]lspSymbol, int) {
+		symbols := []lspSymbol{}
+		for i < len(headings) && headings[i].Level > level {
+			heading := headings[i]
+			_, end := lineAt(text, heading.pos)
+			symbol := lspSymbol{Name: heading.Text, Kind: lspSymbolString, Range: toLSPRange(text, heading.pos, end)}
+			symbol.SelectionRange = symbol.Range
+			symbol.Children, i = build(i+1, heading.Level)
+			symbols = append(symbols, symbol)
+		}
+		return symbols, i
+	}
+	symbols, _ := build(0, 0)
+	return symbols
+}
+
+// findLine looks for the first line of text that starts with prefix after up to 3 spaces,
+// matching it the way labels match
+func findLine(text string, prefix string) (int, bool) {
+	prefix = normalizeLabel(prefix)
+	for pos := 0; pos < len(text); {
+		line, end := lineAt(text, pos)
+		if lineIndent(line) <= 3 && strings.HasPrefix(normalizeLabel(line), prefix) {
+			return pos + len(line) - len(strings.TrimLeft(line, " ")), true
+		}
+		pos = end + 1
+	}
+	return 0, false
+}
+
+// definition finds what the link, footnote or reference at pos leads to
+func (s *lspServer) definition(uri string, text string, pos int) *lspLocation {
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	line, _ := lineAt(text, lineStart)
+	line = strings.TrimSuffix(line, "\n")
+	offset := pos - lineStart
+	for i := 0; i < len(line); i++ {
+		if line[i] != '[' || i > offset {
+			continue
+		}
+		if label, end, ok := parseFootnoteLabel(line, i); ok && offset <= end {
+			if def, found := findLine(text, "[^"+label+"]:"); found {
+				return &lspLocation{URI: uri, Range: toLSPRange(text, def, def)}
+			}
+			return nil
+		}
+		// the link is parsed the way the converter parses it, the destination ends up in the links
+		state := parserState{conf: s.config(uriToPath(uri)), doc: &docState{src: line}}
+		if _, end := state.parseLink(line, i); end > i && offset <= end && len(state.doc.links) > 0 {
+			return s.linkDefinition(uri, text, state.doc.links[0].text)
+		}
+		// a reference link, `[text][label]`, `[label][]` or `[label]`
+		close := strings.IndexByte(line[i:], ']')
+		if close < 0 {
+			continue
+		}
+		label := line[i+1 : i+close]
+		if rest := line[i+close+1:]; strings.HasPrefix(rest, "[") {
+			if end := strings.IndexByte(rest, ']'); end > 1 {
+				label = rest[1:end]
+			}
+			close += strings.IndexByte(rest, ']') + 1
+		}
+		if offset <= i+close && label != "" {
+			if def, found := findLine(text, "["+label+"]:"); found {
+				return &lspLocation{URI: uri, Range: toLSPRange(text, def, def)}
+			}
+		}
+	}
+	return nil
+}
+
+// linkDefinition finds the page a link leads to, and the heading on it for a `#id`
+func (s *lspServer) linkDefinition(uri string, text string, dest string) *lspLocation {
+	if dest == "" || isExternalURL(dest) || strings.HasPrefix(dest, "/") {
+		return nil
+	}
+	target, fragment, _ := strings.Cut(dest, "#")
+	target, _, _ = strings.Cut(target, "?")
+	targetURI := uri
+	if target != "" {
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		path := filepath.Join(filepath.Dir(uriToPath(uri)), filepath.FromSlash(target))
+		if strings.HasSuffix(path, ".html") {
+			if _, err := os.Stat(path); err != nil {
+				// the page is made from the markdown
+				path = strings.TrimSuffix(path, ".html") + ".md"
+			}
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+		targetURI = pathToURI(path)
+		if !strings.HasSuffix(path, ".md") {
+			return &lspLocation{URI: targetURI}
+		}
+		var ok bool
+		if text, ok = s.text(targetURI); !ok {
+			return &lspLocation{URI: targetURI}
+		}
+	}
+	if fragment != "" {
+		for _, heading := range s.convert(targetURI, text).doc.headings {
+			if heading.ID == fragment {
+				return &lspLocation{URI: targetURI, Range: toLSPRange(text, heading.pos, heading.pos)}
+			}
+		}
+	}
+	if target == "" {
+		return nil
+	}
+	return &lspLocation{URI: targetURI}
+}
+
+// completion completes the destination of a link written up to pos, with the files
+// of src_dir or, after a `#`, the ids of the headings of the page
+func (s *lspServer) completion(uri string, text string, pos int) []lspCompletion {
+	items := []lspCompletion{}
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	open := strings.LastIndex(text[lineStart:pos], "](")
+	if open < 0 {
+		return items
+	}
+	start := lineStart + open + 2
+	typed := text[start:pos]
+	if strings.ContainsAny(typed, " )") {
+		return items
+	}
+	edit := lspTextEdit{Range: toLSPRange(text, start, pos)}
+	if strings.HasPrefix(typed, "#") {
+		for _, heading := range s.convert(uri, text).doc.headings {
+			edit.NewText = "#" + heading.ID
+			items = append(items, lspCompletion{Label: edit.NewText, Kind: lspCompletionRef, TextEdit: edit})
+		}
+		return items
+	}
+	if s.srcDir == "" {
+		return items
+	}
+	dir := filepath.Dir(uriToPath(uri))
+	filepath.WalkDir(s.srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == s.srcDir {
+			return err
+		}
+		if entry.Name()[0] == '.' || (entry.IsDir() && s.conf.Components != "" && filepath.Clean(path) == filepath.Clean(s.conf.Components)) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || path == uriToPath(uri) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		// pages are linked by the html they turn into
+		if strings.Contains(entry.Name(), ".md") {
+			rel = strings.Split(rel, ".md")[0] + ".html"
+		}
+		edit.NewText = filepath.ToSlash(rel)
+		items = append(items, lspCompletion{Label: edit.NewText, Kind: lspCompletionFile, TextEdit: edit})
+		return nil
+	})
+	return items
+}
+
+// handle answers a message, a notification gets no answer
+func (s *lspServer) handle(msg lspMessage) {
+	var doc struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		RootURI string `json:"rootUri"`
+	}
+	var at lspPositionParams
+	json.Unmarshal(msg.Params, &doc)
+	json.Unmarshal(msg.Params, &at)
+	uri := doc.TextDocument.URI
+
+	var result any
+	switch msg.Method {
+	case "initialize":
+		if s.srcDir == "" {
+			s.srcDir = uriToPath(doc.RootURI)
+		}
+		result = map[string]any{
+			"capabilities": map[string]any{
+				// the whole text is sent on every change
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]any{"triggerCharacters": []string{"(", "/", "#"}},
+			},
+			"serverInfo": map[string]any{"name": "ssg"},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		s.docs[uri] = strings.ReplaceAll(doc.TextDocument.Text, "\r\n", "\n")
+		s.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		if len(doc.ContentChanges) > 0 {
+			s.docs[uri] = strings.ReplaceAll(doc.ContentChanges[len(doc.ContentChanges)-1].Text, "\r\n", "\n")
+		}
+		s.publishDiagnostics(uri)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.publishDiagnostics(uri)
+	case "textDocument/documentSymbol":
+		text, _ := s.text(uri)
+		result = s.symbols(uri, text)
+	case "textDocument/definition":
+		text, _ := s.text(uri)
+		if location := s.definition(uri, text, fromLSPPosition(text, at.Position)); location != nil {
+			result = location
+		}
+	case "textDocument/completion":
+		text, _ := s.text(uri)
+		result = s.completion(uri, text, fromLSPPosition(text, at.Position))
+	default:
+		if msg.ID != nil {
+			s.write(lspResponse{JSONRPC: "2.0", ID: msg.ID, Error: &lspError{Code: lspMethodNotFound, Message: "unknown method " + msg.Method}})
+		}
+		return
+	}
+	if msg.ID != nil {
+		s.write(lspResponse{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+}
+
+// serve answers messages until the client exits, returns the exit code
+func (s *lspServer) serve() int {
+	for {
+		msg, err := s.read()
+		if errors.Is(err, errLSPParse) {
+			// a broken message does not end the session, the id it had is not known
+			s.write(lspResponse{JSONRPC: "2.0", Error: &lspError{Code: lspParseError, Message: err.Error()}})
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Failed to read message. Error:", err)
+			}
+			return 1
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		s.handle(msg)
+	}
+}
+
+// lspMain runs `ssg lsp`
+func lspMain(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	srcDirPtr := flags.String("src_dir", "", "path to blog input files, the root of the workspace when empty")
+	confPtr := flags.String("config", "", "path to a json config file")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	conf, err := LoadConfig(*confPtr)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	server := lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, srcDir: *srcDirPtr, conf: &conf, docs: map[string]string{}}
+	return server.serve()
+}
@@ -1,4 +1,4 @@
-package main
+package ssg
 
 /*
 @glossory:
@@ -21,12 +21,7 @@ and you don't even know what you did incorrectly
 ---
 - md conversion
   * text formatting to work with newline and linebreak
-	* link
-	* ul
-	* ol
-- table? (probably a custom table)
 - custom header
-- custom components
 @improvements:
 - treat parsing error as values and do not exit the programme upon getting an error
   - continue parsing the string, checking for errors and notifying where there is an error
@@ -36,19 +31,56 @@ and you don't even know what you did incorrectly
 - headings
 - paragraphs
 - linebreak
+-- two trailing spaces or a backslash
 - text formatting
 -- italic
 -- bold
 -- italicBold
+-- underscores and the CommonMark delimiter run rules
+-- inline code
+-- links
+-- autolinks and bare urls
+-- strikethrough, highlight, superscript, subscript and insert extensions
+- heading ids and table of contents
+- blockquotes
+- lists
+-- ul
+-- ol
+-- task lists
+- footnotes
+- thematic breaks
+- code blocks
+- tables
+-- pipe tables
+-- grid tables (the custom table)
+- custom components
+- backslash escapes and html escaping of text
+- raw html
+- sanitizing untrusted content
+- unicode text, columns counted in characters
+- input normalisation (bom, line endings, tabs, utf-16 and latin-1)
+- diagnostics with the file, line and column and an excerpt of the line
+-- json and sarif output for other tools
+-- link checker
+- lint command with rules that can be turned off and autofixes
+- fmt command that keeps the html the same
+- lsp command with live diagnostics, go to definition, heading symbols and link completion
+- ssg package with Convert and Build, the command is in cmd/ssg
 */
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"html"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type pathState struct {
@@ -59,37 +91,70 @@ type pathState struct {
 
 	// output items
 	dst_path string
+	// src_path relative to the root source directory, picks the config section
+	rel_path string
 }
 
 var paraMap []string = []string{"<p>", "</p>"}
 var italicBoldMap [][]string = [][]string{{"<i>", "</i>"}, {"<b>", "</b>"}, {"<i><b>", "</b></i>"}}
 
+// inline extensions, each of them has to be turned on in the config
+var extensionMap map[string][]string = map[string][]string{
+	"~~": {"<del>", "</del>"},
+	"==": {"<mark>", "</mark>"},
+	"^":  {"<sup>", "</sup>"},
+	"~":  {"<sub>", "</sub>"},
+	"++": {"<ins>", "</ins>"},
+}
+
 const (
-	TokenNone = iota + 0
-	TokenHeading
-	TokenFormat
-	TokenSpace
-	TokenNewline
+	tokenNone = iota + 0
+	tokenHeading
+	tokenFormat
+	tokenSpace
+	tokenNewline
+	tokenQuote
+	tokenFence
+	tokenBullet
+	tokenOrdered
+	tokenComponent
+	tokenHTML
+	tokenBracket
 )
 
 const (
-	ParseSuccess = iota + 0
-	ParseError
+	parseSuccess = iota + 0
+	parseError
+	parseWarning
 )
 
-func Tokenize(ch rune) int {
-	operation := TokenNone
+func tokenize(ch rune) int {
+	operation := tokenNone
 	switch ch {
 	case '#':
-		operation = TokenHeading
-	case '*':
-		operation = TokenFormat
+		operation = tokenHeading
+	case '*', '_':
+		operation = tokenFormat
 	case ' ':
-		operation = TokenSpace
+		operation = tokenSpace
 	case '\n':
-		operation = TokenNewline
+		operation = tokenNewline
+	case '>':
+		operation = tokenQuote
+	case '`', '~':
+		operation = tokenFence
+	case '-', '+':
+		operation = tokenBullet
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		operation = tokenOrdered
+	case ':':
+		operation = tokenComponent
+	case '<':
+		operation = tokenHTML
+	case '[':
+		operation = tokenBracket
 	default:
-		operation = TokenNone
+		operation = tokenNone
 	}
 
 	return operation
@@ -98,72 +163,104 @@ func Tokenize(ch rune) int {
 var hMap []string = []string{"h1", "h2", "h3", "h4", "h5", "h6"}
 
 const (
-	HmdNone = iota + 0
-	HmdToken
-	HmdText
-	HmdDone
-	HmdError
+	hmdNone = iota + 0
+	hmdToken
+	hmdText
+	hmdDone
+	hmdError
 )
 
-type ParsedToken struct {
+type parsedToken struct {
 	str           string
 	pos           int
 	row           int
 	col           int
 	statusCode    int
 	statusMessage string
+	// the check that reported it, like `heading-space`
+	rule string
+	// heading level, text and explicit `{#id}` if one was written
+	level int
+	text  string
+	id    string
 }
 
 type paraState struct {
-	begin    bool
-	active   bool
-	surround bool
-	end      bool
+	active bool
+	end    bool
+	// raw lines of the paragraph, they are parsed for inline elements once the paragraph ends
+	buffer string
 }
 
-type ParserState struct {
+// docState is shared by the document and every container nested inside of it
+type docState struct {
+	headings []tocEntry
+	ids      slugger
+	// every warning and error found while parsing
+	diags []parsedToken
+	tasks taskCount
+	// the input of the whole document, for finding where something in a container was written
+	src string
+	// footnotes by their label, the references to them in the order they were parsed and
+	// where to look for the next reference in src
+	footnotes      map[string]*footnote
+	footnoteRefs   []parsedToken
+	footnoteSearch int
+	footnoteDupes  []parsedToken
+	// the destinations of links for the link checker and where to look for the next one in src
+	links      []parsedToken
+	linkSearch int
+}
+
+type parserState struct {
 	inpStr      string
 	outStr      string
 	currPos     int
 	writeBuffer string
-	isSpace     bool
-	isNewLine   bool
 	para        paraState
+	conf        *Config
+	doc         *docState
+	// set for the items of a tight list, their paragraphs are written without <p>
+	tight bool
+	// set while parsing the text of a link, no other link can be made inside of it
+	inLink bool
+	// where the input of a container starts in the whole document
+	base int
 }
 
-type MdParser interface {
-	printParseError(info ParsedToken) ParserState
-	writeToOutputStr() ParserState
+type mdParser interface {
+	writeToOutputStr() parserState
 }
 
-func ParseHeading(str string, pos int) (res ParsedToken) {
+func parseHeading(str string, pos int) (res parsedToken) {
 	res.str = ""
 	res.pos = pos
-	res.row = 1
-	res.col = 0
 
 	hInd := 0
-	hStatus := HmdNone
+	hStatus := hmdNone
 	rawBuffer := ""
 	parsedBuffer := ""
 	i := pos
-	for i = pos; i < len(str); i++ {
-		res.col++
-		ch := rune(str[i])
+	size := 1
+	for i = pos; i < len(str); i += size {
+		// the text is utf-8, a character can take more than one byte
+		var ch rune
+		ch, size = utf8.DecodeRuneInString(str[i:])
 		switch ch {
 		case '#':
-			if hStatus < HmdText {
+			if hStatus < hmdText {
 				// we are going through the list of # to see what kind of heading
 				// this will be
 				hInd++
-				hStatus = HmdToken
+				hStatus = hmdToken
 				if hInd > len(hMap) {
 					// we see more than 6 `#` characters. Those are invalid
 					res.str = rawBuffer
-					res.statusCode = ParseError
-					res.statusMessage = "headings can only have at max 6 `#` characters to declare them."
+					res.statusCode = parseWarning
+					res.statusMessage = "headings can only have at max 6 `#` characters to declare them, this is written as text"
+					res.rule = "heading-level"
 					res.pos = i
-					hStatus = HmdError
+					hStatus = hmdError
 				}
 			} else {
 				// we are currently writing heading text and see another # character
@@ -171,38 +268,33 @@ func ParseHeading(str string, pos int) (res ParsedToken) {
 				parsedBuffer += "#"
 			}
 			rawBuffer += "#"
-		case ' ':
-			if hStatus == HmdToken {
+		case ' ', '\t':
+			if hStatus == hmdToken {
 				// we were going through the list of headings and found a ` `
 				// this means that text writing should begin now
-				hStatus = HmdText
-				parsedBuffer += "\n<" + hMap[hInd-1] + ">"
+				hStatus = hmdText
 			} else {
 				// in normal cases we will jsut copy the space
-				parsedBuffer += " "
+				parsedBuffer += string(ch)
 			}
-			rawBuffer += " "
+			rawBuffer += string(ch)
 		case '\n':
 			// a newline marks the end of a header
 			// we will complete parsing and return
 			rawBuffer += "\n"
-			parsedBuffer += "</" + hMap[hInd-1] + ">\n"
-
-			res.str = parsedBuffer
-			res.statusCode = ParseSuccess
+			finishHeading(&res, hInd, removeClosingHashes(strings.Trim(parsedBuffer, " \t")))
 			res.pos = i
-			hStatus = HmdDone
-			res.row++
-			res.col = 0
+			hStatus = hmdDone
 		default:
 			// handle string
-			if hStatus == HmdToken {
+			if hStatus == hmdToken {
 				// if we were going throuhg heading `#` characters and found a normal text character
 				// that means that the heading is invalid
 				res.str = rawBuffer
-				res.statusCode = ParseError
-				res.statusMessage = "An unsupported character was found directly after #. This is not valid"
-				hStatus = HmdError
+				res.statusCode = parseWarning
+				res.statusMessage = "a heading needs a space after the `#` characters, this is written as text"
+				res.rule = "heading-space"
+				hStatus = hmdError
 				// we want this to be re-evaluated after exiting since this will be treated as an independant character -
 				// and in the event that is some other markdown character that needs evaluation, this ensures that we
 				// do not skip it
@@ -214,141 +306,472 @@ func ParseHeading(str string, pos int) (res ParsedToken) {
 			}
 			rawBuffer += string(ch)
 		}
-		if hStatus >= HmdDone {
+		if hStatus >= hmdDone {
 			break
 		}
 	}
-	if hStatus < HmdDone {
-		res.str = rawBuffer
-		res.statusCode = ParseError
-		res.statusMessage = "Header was not terminated, as such it is invalid"
-		res.pos = i
+	if hStatus < hmdDone {
+		// the file ended on the heading line, that still is a complete heading
+		finishHeading(&res, hInd, removeClosingHashes(strings.Trim(parsedBuffer, " \t")))
+		res.pos = len(str) - 1
 	}
 	return res
 }
 
-func ClampFloor(val int, floor int) int {
+func finishHeading(res *parsedToken, level int, text string) {
+	res.level = level
+	res.text, res.id = splitHeadingID(strings.Trim(text, " \t\n"))
+	res.statusCode = parseSuccess
+}
+
+// removeClosingHashes drops the optional closing sequence of `## heading ##`.
+// the `#` characters only close the heading if there is a space before them,
+// `## C#` keeps its `#`
+func removeClosingHashes(text string) string {
+	stripped := strings.TrimRight(text, "#")
+	if stripped == "" {
+		return ""
+	}
+	last := stripped[len(stripped)-1]
+	if last != ' ' && last != '\t' {
+		return text
+	}
+	return strings.TrimRight(stripped, " \t")
+}
+
+// lineIndent returns the number of leading spaces, a tab counts up to the next tab stop
+func lineIndent(line string) int {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == ' ' {
+			indent++
+		} else if line[i] == '\t' {
+			indent += 4 - indent%4
+		} else {
+			break
+		}
+	}
+	return indent
+}
+
+// isSetextUnderline checks if a line is a run of `=` (h1) or `-` (h2) that turns the
+// paragraph above it into a heading. returns the heading level, 0 if it is not an underline.
+// `- - -` has spaces in between so it is not an underline, and neither is a list item like `- a`
+func isSetextUnderline(line string) int {
+	if lineIndent(line) > 3 {
+		return 0
+	}
+	line = strings.TrimRight(strings.TrimLeft(line, " "), " \t\n")
+	if line == "" {
+		return 0
+	}
+	if strings.Trim(line, "=") == "" {
+		return 1
+	}
+	if strings.Trim(line, "-") == "" {
+		return 2
+	}
+	return 0
+}
+
+func clampFloor(val int, floor int) int {
 	if val < floor {
 		return floor
 	}
 	return val
 }
 
-func ClampCeil(val int, ceil int) int {
+func clampCeil(val int, ceil int) int {
 	if val > ceil {
 		return ceil
 	}
 	return val
 }
 
-func (state ParserState) printParseError(info ParsedToken) {
-	// print a detailed error message and exit the program
-	fmt.Printf("ERROR:: %s.\nValue: ...%s > %s... \nLocation => line: %d, col: %d\n",
-		info.statusMessage, 
-    state.inpStr[ClampFloor(state.currPos-15, 0):info.pos], 
-    state.inpStr[info.pos:ClampCeil(info.pos+15, len(state.inpStr)-1)],
-		info.row, info.col)
+// report keeps the diagnostic with the rest of the document, they are printed once the
+// document is converted. info.pos is moved to the whole document and the line and column
+// are worked out from there
+func (state *parserState) report(info parsedToken) {
+	info.pos = state.sourcePos(info.pos)
+	info.row, info.col = lineCol(state.doc.src, info.pos)
+	state.doc.diags = append(state.doc.diags, info)
 }
 
-func (state *ParserState) writeToOutputStr() {
+// sourcePos moves a position in the input of a container to the whole document. the markers
+// of the container are gone from its input, so the line pos is on is looked for in the
+// document after the place where the container starts
+func (state *parserState) sourcePos(pos int) int {
+	if state.inpStr == state.doc.src {
+		return pos
+	}
+	pos = clampCeil(clampFloor(pos, 0), len(state.inpStr))
+	lineStart := strings.LastIndexByte(state.inpStr[:pos], '\n') + 1
+	lineEnd := strings.IndexByte(state.inpStr[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(state.inpStr) - lineStart
+	}
+	line := state.inpStr[lineStart : lineStart+lineEnd]
+	found := strings.Index(state.doc.src[state.base:], line)
+	if line == "" || found < 0 {
+		return state.base
+	}
+	return state.base + found + pos - lineStart
+}
+
+// lineCol returns the line and column of pos, both starting at 1.
+// the column counts characters and not bytes
+func lineCol(str string, pos int) (int, int) {
+	pos = clampCeil(pos, len(str))
+	lineStart := strings.LastIndexByte(str[:pos], '\n') + 1
+	return strings.Count(str[:pos], "\n") + 1, columns(str[lineStart:pos]) + 1
+}
+
+func (state *parserState) writeToOutputStr() {
+	// prefix write: the paragraph has to be written before whatever ended it
 	if state.para.end {
-		if state.para.active {
-			state.outStr += "</p>\n"
+		if state.para.active && state.tight {
+			// paragraphs of a tight item are not wrapped, a newline keeps them apart from what came before
+			if state.outStr != "" && !strings.HasSuffix(state.outStr, "\n") {
+				state.outStr += "\n"
+			}
+			state.outStr += state.parseInline(strings.TrimSuffix(state.para.buffer, "\n"))
+			state.para.active = false
+			state.para.buffer = ""
+		} else if state.para.active {
+			state.outStr += "\n<p>" + state.parseInline(state.para.buffer) + "</p>\n"
 			state.para.active = false
-			state.para.end = false
+			state.para.buffer = ""
 		}
-	}
-	if state.para.begin {
-		state.outStr += "\n<p>"
-		state.para.active = true
-		state.para.begin = false
+		state.para.end = false
 	}
 	state.outStr += state.writeBuffer
-	if state.para.surround {
-		state.outStr += "</p>\n"
-		state.para.surround = false
-		state.para.active = false
-		state.para.begin = false
-		state.para.end = false
+}
+
+// writeHeading gives the parsed heading its id, registers it for the toc
+// and returns the html for it
+func (state *parserState) writeHeading(info parsedToken) string {
+	conf := state.conf.Headings
+	// the heading text goes through the same inline parsing a paragraph does
+	content := state.parseInline(info.text)
+	// the toc and the slug only care about the text, not the formatting or footnote references
+	plain := html.UnescapeString(stripTags(stripFootnoteRefs(content)))
+	id := info.id
+	if id != "" && !isHeadingID(id) {
+		var bad parsedToken
+		bad.str = "{#" + id + "}"
+		bad.pos = state.currPos
+		if line, _ := lineAt(state.inpStr, state.currPos); strings.Contains(line, bad.str) {
+			bad.pos += strings.Index(line, bad.str)
+		}
+		bad.statusCode = parseWarning
+		bad.statusMessage = "the id `" + id + "` can only have letters, digits, `-`, `_`, `.` and `:`, the id is made from the text"
+		bad.rule = "heading-id"
+		state.report(bad)
+		id = ""
+	}
+	if id == "" {
+		id = slugify(plain, conf.Slug)
+	}
+	id = state.doc.ids.unique(id, conf.Slug.Separator)
+	state.doc.headings = append(state.doc.headings, tocEntry{Level: info.level, Text: plain, ID: id, pos: state.sourcePos(state.currPos)})
+
+	text := content
+	if conf.Anchor != "" {
+		anchor := "<a class=\"anchor\" href=\"#" + escapeHTML(id) + "\">" + conf.Anchor + "</a>"
+		if conf.AnchorPosition == "before" {
+			text = anchor + " " + text
+		} else {
+			text = text + " " + anchor
+		}
 	}
+	tag := hMap[info.level-1]
+	return "\n<" + tag + " id=\"" + escapeHTML(id) + "\">" + text + "</" + tag + ">\n"
+}
+
+// processMD converts a markdown string into an html article using the default config
+func processMD(str string) string {
+	conf := DefaultConfig()
+	return processMDConfig(str, &conf).outStr
 }
 
-func ProcessMD(str string) string {
-	var state ParserState
+func processMDConfig(str string, conf *Config) parserState {
+	str = removePlaceholders(str)
+	var state parserState
 	state.inpStr = str
-	state.outStr = "<article>\n"
+	state.conf = conf
+	state.doc = &docState{src: str}
+	state.parseBlocks()
+	footnotes := state.writeFootnotes()
+	state.outStr = "<article>\n" + state.outStr + footnotes + "\n</article>"
+	state.outStr = strings.Replace(state.outStr, tocPlaceholder, renderToc(state.doc.headings, conf.Toc), 1)
+	if conf.Sanitize.Enabled {
+		state.outStr = state.sanitize(state.outStr)
+	}
+
+	return state
+}
+
+// nested runs the block parser over the content of a container like a blockquote
+// or a list item and returns the html for it
+func (state *parserState) nested(str string, tight bool) string {
+	child := parserState{
+		inpStr: strings.TrimSuffix(str, "\n"),
+		conf:   state.conf,
+		doc:    state.doc,
+		tight:  tight,
+		// the container is parsed before currPos moves past it
+		base: state.sourcePos(state.currPos),
+	}
+	child.parseBlocks()
+	return child.outStr
+}
+
+// addParaLine adds the line ending at lineEnd to the current paragraph
+func (state *parserState) addParaLine(line string, lineEnd int) {
+	state.para.buffer += strings.TrimLeft(line, " \t")
+	state.para.active = true
+	state.currPos = lineEnd
+}
+
+func (state *parserState) writeList(list parsedList) string {
+	tag := "ul"
+	out := "\n<ul>\n"
+	if list.ordered {
+		tag = "ol"
+		out = "\n<ol>\n"
+		if list.start != 1 {
+			out = "\n<ol start=\"" + strconv.Itoa(list.start) + "\">\n"
+		}
+	}
+	for _, item := range list.items {
+		task, checked, content := taskMarker(item)
+		if !task {
+			out += "<li>" + state.nested(item, !list.loose) + "</li>\n"
+			continue
+		}
+		checkbox := "<input type=\"checkbox\" disabled=\"\" /> "
+		if checked {
+			checkbox = "<input type=\"checkbox\" checked=\"\" disabled=\"\" /> "
+			state.doc.tasks.Done++
+		} else {
+			state.doc.tasks.Open++
+		}
+		html := state.nested(content, !list.loose)
+		// in a loose list the checkbox goes inside of the first paragraph
+		if strings.HasPrefix(html, "\n<p>") {
+			html = "\n<p>" + checkbox + html[len("\n<p>"):]
+		} else {
+			html = checkbox + html
+		}
+		out += "<li class=\"task-list-item\">" + html + "</li>\n"
+	}
+	return out + "</" + tag + ">\n"
+}
+
+func (state *parserState) parseBlocks() {
+	// every iteration begins at the start of a line, a block consumes its lines
+	// and leaves currPos on the last character it used
 	for state.currPos = 0; state.currPos < len(state.inpStr); state.currPos++ {
-    isNewLine := false
-    isSpace := false
-		ch := state.inpStr[state.currPos]
-		operation := Tokenize(rune(ch))
+		line, lineEnd := lineAt(state.inpStr, state.currPos)
+		// up to 3 spaces can come before a block without changing it
+		indent := lineIndent(line)
+		var operation int
+		if isBlank(line) {
+			operation = tokenNewline
+		} else if indent <= 3 {
+			ch, _ := utf8.DecodeRuneInString(strings.TrimLeft(line, " \t"))
+			operation = tokenize(ch)
+		} else if !state.para.active {
+			operation = tokenSpace
+		} else {
+			// indented lines can not end a paragraph
+			operation = tokenNone
+		}
+		if found, end := isTocMarker(state.inpStr, state.currPos); found {
+			// the toc is its own block, any open paragraph ends here
+			state.para.end = true
+			state.writeBuffer += tocPlaceholder
+			state.writeToOutputStr()
+			state.writeBuffer = ""
+			state.currPos = end
+			continue
+		}
+		if level := isSetextUnderline(line); level > 0 && state.para.active {
+			// the underline turns the whole paragraph above it into a heading
+			var token parsedToken
+			finishHeading(&token, level, state.para.buffer)
+			state.para.active = false
+			state.para.buffer = ""
+			state.writeBuffer += state.writeHeading(token)
+			state.writeToOutputStr()
+			state.writeBuffer = ""
+			state.currPos = lineEnd
+			continue
+		}
+		if _, ok := parseTableDelimiter(line); ok && state.para.active {
+			// the last line of the paragraph is the header of the table
+			paraText := strings.TrimSuffix(state.para.buffer, "\n")
+			headerStart := strings.LastIndexByte(paraText, '\n') + 1
+			table := parseTable(paraText[headerStart:], state.inpStr, state.currPos)
+			if table.statusCode == parseSuccess {
+				state.para.buffer = paraText[:headerStart]
+				state.para.active = state.para.buffer != ""
+				state.para.end = true
+				state.writeBuffer += state.writeTable(table)
+				state.writeToOutputStr()
+				state.writeBuffer = ""
+				state.currPos = table.pos
+				continue
+			}
+		}
+		if isGridBorder(line) {
+			table := parseGridTable(state.inpStr, state.currPos)
+			if table.statusCode != parseSuccess {
+				// the broken table is kept as a paragraph, all of it so that the borders
+				// further down do not get read as another table. it is only a warning,
+				// the text may not have been meant as a table
+				state.report(table.parsedToken)
+				state.addParaLine(state.inpStr[state.currPos:table.end+1], table.end)
+				state.writeBuffer = ""
+				continue
+			}
+			// the caption is either the last line of the paragraph before or the line after
+			paraText := strings.TrimSuffix(state.para.buffer, "\n")
+			captionStart := strings.LastIndexByte(paraText, '\n') + 1
+			nextLine := ""
+			if table.pos+1 < len(state.inpStr) {
+				nextLine, _ = lineAt(state.inpStr, table.pos+1)
+			}
+			if caption, cols, ok := parseCaption(paraText[captionStart:]); ok && state.para.active {
+				table.caption, table.headerCols = caption, cols
+				state.para.buffer = paraText[:captionStart]
+				state.para.active = state.para.buffer != ""
+			} else if caption, cols, ok := parseCaption(nextLine); ok {
+				table.caption, table.headerCols = caption, cols
+				_, table.pos = lineAt(state.inpStr, table.pos+1)
+			}
+			state.para.end = true
+			state.writeBuffer += state.writeGridTable(table)
+			state.writeToOutputStr()
+			state.writeBuffer = ""
+			state.currPos = table.pos
+			continue
+		}
+		if isThematicBreak(line) {
+			// after the setext check, `---` below a paragraph is a heading
+			state.para.end = true
+			state.writeBuffer += "\n<hr />\n"
+			state.writeToOutputStr()
+			state.writeBuffer = ""
+			state.currPos = lineEnd
+			continue
+		}
 		switch operation {
-		case TokenHeading:
-			parsedToken := ParseHeading(state.inpStr, state.currPos)
-			if parsedToken.statusCode != ParseSuccess {
-				state.printParseError(parsedToken)
-				os.Exit(0)
+		case tokenHeading:
+			token := parseHeading(state.inpStr, state.currPos+strings.IndexByte(line, '#'))
+			if token.statusCode == parseSuccess {
+				state.writeBuffer += state.writeHeading(token)
+				state.currPos = token.pos
+				state.para.end = true
+				break
 			}
-			state.writeBuffer += parsedToken.str
-			state.currPos = parsedToken.pos
+			// not a heading after all, warn about it and keep the line as text
+			state.report(token)
+			state.addParaLine(line, lineEnd)
+		case tokenQuote:
+			token := parseBlockquote(state.inpStr, state.currPos)
+			state.writeBuffer += "\n<blockquote>" + state.nested(token.text, false) + "</blockquote>\n"
+			state.currPos = token.pos
 			state.para.end = true
-		case TokenSpace:
-			if !state.para.active {
-				state.para.begin = true
+		case tokenFence:
+			token := parseCodeFence(state.inpStr, state.currPos)
+			if token.statusCode != parseSuccess {
+				state.addParaLine(line, lineEnd)
+				break
 			}
-			if state.isSpace {
-				state.writeBuffer += "<br />"
-			} else {
-				isSpace = true
-				state.writeBuffer += " "
+			state.writeBuffer += token.str
+			state.currPos = token.pos
+			state.para.end = true
+		case tokenSpace:
+			// 4 or more spaces of indentation outside of a paragraph is code
+			token := parseIndentedCode(state.inpStr, state.currPos)
+			state.writeBuffer += token.str
+			state.currPos = token.pos
+			state.para.end = true
+		case tokenBullet, tokenOrdered, tokenFormat:
+			marker, ok := parseListMarker(line)
+			if !ok || (state.para.active && !marker.interrupts()) {
+				state.addParaLine(line, lineEnd)
+				break
 			}
-		case TokenNewline:
-			if !state.para.active {
-				// this is for when a newline is written and we want to check if a new paragraph must begin
-				state.para.begin = true
+			list := parseList(state.inpStr, state.currPos)
+			state.writeBuffer += state.writeList(list)
+			state.currPos = list.pos
+			state.para.end = true
+		case tokenComponent:
+			comp := parseComponent(state.inpStr, state.currPos)
+			if comp.statusCode == parseError {
+				state.addParaLine(line, lineEnd)
+				break
 			}
-			if state.isNewLine {
-				// we expect that by the second newline we are already in a paragraph. that is the correct behavior
-				// normally, I would have an assertion here to check that para.active is true but this would suffice
-				// the rest I shall catch through tests
-				state.para.end = true
-			} else {
-				isNewLine = true
-				state.writeBuffer += "\n"
+			if comp.statusCode == parseWarning {
+				state.report(comp.parsedToken)
 			}
-		default:
-			state.writeBuffer += string(ch)
-			if !state.para.active {
-				state.para.begin = true
+			state.writeBuffer += state.writeComponent(comp, state.currPos)
+			state.currPos = comp.pos
+			state.para.end = true
+		case tokenHTML:
+			kind := htmlBlockStart(line, state.para.active)
+			if kind == 0 {
+				state.addParaLine(line, lineEnd)
+				break
 			}
+			// markdown is not parsed inside of html blocks
+			token := parseHTMLBlock(state.inpStr, state.currPos, kind)
+			state.writeBuffer += state.writeHTMLBlock(token)
+			state.currPos = token.pos
+			state.para.end = true
+		case tokenBracket:
+			// a footnote definition can not end a paragraph
+			def := parseFootnoteDef(state.inpStr, state.currPos)
+			if state.para.active || def.statusCode != parseSuccess {
+				state.addParaLine(line, lineEnd)
+				break
+			}
+			state.addFootnote(def)
+			state.currPos = def.pos
+		case tokenNewline:
+			// an empty line ends the paragraph
+			state.para.end = true
+			state.currPos = lineEnd
+		default:
+			// anything else is paragraph text, the line is kept until the paragraph ends
+			state.addParaLine(line, lineEnd)
 		}
 		state.writeToOutputStr()
 		state.writeBuffer = ""
-    state.isSpace = isSpace
-    state.isNewLine = isNewLine
 	}
 	// incase something was being parsed as we reached end of string
 	// we will attempt to flush the write buffer to the output string
-  if state.para.active {
-    state.para.end = true
-    state.writeToOutputStr()
-  }
-	state.outStr += "\n</article>"
-
-	return state.outStr
+	if state.para.active {
+		state.para.end = true
+		state.writeToOutputStr()
+	}
 }
 
-func process(src_path string, dst_path string) {
+// process converts the files of src_path into dst_path and returns the diagnostics of all of them
+func process(ctx context.Context, src_path string, dst_path string, rel_path string, conf *Config) ([]Diagnostic, error) {
+	var diags []Diagnostic
 	var state pathState = pathState{
 		src_path:  src_path,
 		src_files: make([]string, 0, 8),
 		src_dirs:  make([]string, 0, 8),
 		dst_path:  dst_path,
+		rel_path:  rel_path,
 	}
-
 	entries, err := os.ReadDir(state.src_path)
 	if err != nil {
-		log.Fatal("Failed to read directory:", state.src_path, "Error:", err)
+		return diags, fmt.Errorf("failed to read directory %s: %w", state.src_path, err)
 	}
 	for _, file := range entries {
 		if file.Name()[0] == '.' {
@@ -364,46 +787,150 @@ func process(src_path string, dst_path string) {
 
 	// process_files
 	for _, fname := range state.src_files {
+		// a build can be stopped between two files
+		if err := ctx.Err(); err != nil {
+			return diags, err
+		}
 		fpath := state.src_path + "/" + fname
 		file_bytes, err := os.ReadFile(fpath)
 		if err != nil {
-			log.Fatal("Failed to read file:", fpath, ". Error:", err)
+			return diags, fmt.Errorf("failed to read file %s: %w", fpath, err)
 		}
 		if strings.Contains(fname, ".md") {
 			// process_md_file
-			file_conv := ProcessMD(string(file_bytes))
-			file_bytes = []byte(file_conv)
 			fname_split := strings.Split(fname, ".")
+			page, file_diags, err := convertFile(file_bytes, fpath, fname_split[0], conf.section(filepath.Join(state.rel_path, fname)))
+			diags = append(diags, file_diags...)
+			if err != nil {
+				return diags, err
+			}
+			file_bytes = []byte(page)
 			fname = fname_split[0] + ".html"
 		}
 
 		// write_file
 		wpath := state.dst_path + "/" + fname
-		os.WriteFile(wpath, file_bytes, 0666)
+		err = os.WriteFile(wpath, file_bytes, 0666)
+		if err != nil {
+			return diags, fmt.Errorf("failed to write file %s: %w", wpath, err)
+		}
 	}
 
 	// read directories
 	for _, dirname := range state.src_dirs {
 		sub_src_path := state.src_path + "/" + dirname
+		if conf.Components != "" && filepath.Clean(sub_src_path) == filepath.Clean(conf.Components) {
+			// component templates are used by the pages, they are not pages of their own
+			continue
+		}
 		sub_dst_path := state.dst_path + "/" + dirname
 		err := os.Mkdir(sub_dst_path, 0750)
 		if err != nil && !os.IsExist(err) {
-			log.Fatal("Failed to make directory:", dirname, ". Error:", err)
+			return diags, fmt.Errorf("failed to make directory %s: %w", dirname, err)
+		}
+		sub_diags, err := process(ctx, sub_src_path, sub_dst_path, filepath.Join(state.rel_path, dirname), conf)
+		diags = append(diags, sub_diags...)
+		if err != nil {
+			return diags, err
 		}
-		process(sub_src_path, sub_dst_path)
 	}
+	return diags, nil
 }
 
-func main() {
-	srcDirPtr := flag.String("src_dir", "", "path to blog input files")
-	dstDirPtr := flag.String("dst_dir", "", "path to blog output files")
+// markdownFiles lists the markdown files under src_dir the way process goes through them,
+// relative to src_dir
+func markdownFiles(src_dir string, conf *Config) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(src_dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == src_dir {
+			return nil
+		}
+		if entry.Name()[0] == '.' || (entry.IsDir() && conf.Components != "" && filepath.Clean(path) == filepath.Clean(conf.Components)) {
+			// special files and component templates are not pages
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && strings.Contains(entry.Name(), ".md") {
+			rel, _ := filepath.Rel(src_dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", src_dir, err)
+	}
+	return files, nil
+}
 
-	flag.Parse()
+// Main runs the ssg command line, args are the arguments after the name of the program.
+// it returns the exit code, errors are written to stderr
+func Main(args []string) int {
+	flags := flag.NewFlagSet("ssg", flag.ContinueOnError)
+	srcDirPtr := flags.String("src_dir", "", "path to blog input files")
+	dstDirPtr := flags.String("dst_dir", "", "path to blog output files")
+	confPtr := flags.String("config", "", "path to a json config file")
+	formatPtr := flags.String("diagnostics-format", diagnosticsText, "how warnings and errors are written: text, json or sarif")
+
+	if len(args) > 0 {
+		// subcommands have flags of their own
+		switch args[0] {
+		case "lint":
+			return lintMain(args[1:])
+		case "fmt":
+			return fmtMain(args[1:])
+		case "lsp":
+			return lspMain(args[1:])
+		}
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := checkDiagnosticsFormat(*formatPtr); err != nil {
+		log.Println(err)
+		return 1
+	}
+	// stdout only has the diagnostics when another tool reads them
+	if *formatPtr == diagnosticsText {
+		fmt.Println("Source path:", *srcDirPtr)
+		fmt.Println("Destination path:", *dstDirPtr)
+	}
 
-	fmt.Println("Source path:", *srcDirPtr)
-	fmt.Println("Destination path:", *dstDirPtr)
+	conf, err := LoadConfig(*confPtr)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	diags, err := process(context.Background(), *srcDirPtr, *dstDirPtr, "", &conf)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	err = printDiagnostics(diags, *formatPtr)
+	if err != nil {
+		log.Println("Failed to write diagnostics. Error:", err)
+		return 1
+	}
 
-	process(*srcDirPtr, *dstDirPtr)
+	if *formatPtr == diagnosticsText {
+		fmt.Println("finished reading root directory")
+	}
+	return 0
+}
 
-	fmt.Println("finished reading root directory")
+// parseFlags parses the arguments of a command. when the command stops there it returns
+// false and the exit code, 0 after -help and 2 for flags that are not right
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return 0, false
+	}
+	if err != nil {
+		return 2, false
+	}
+	return 0, true
 }
@@ -1,8 +1,17 @@
-package main
+package ssg
 
 import (
   "testing"
   "fmt"
+  "bufio"
+  "context"
+  "encoding/json"
+  "strconv"
+  "html/template"
+  "os"
+  "path/filepath"
+  "slices"
+  "strings"
 )
 
 func surroundPara(str string) string {
@@ -20,43 +29,52 @@ func surroundArticlePara(str string) string {
 func TestHeadingsCorrect(t* testing.T) {
   fmt.Println("TEST:: Running TestHeadingsCorrect")
   // h1
-  parsed_h1 := ProcessMD("# h1\n")
-  if parsed_h1 != surroundArticle("\n<h1>h1</h1>\n") {
+  parsed_h1 := processMD("# h1\n")
+  if parsed_h1 != surroundArticle("\n<h1 id=\"h1\">h1</h1>\n") {
     t.Fatalf("ERROR:: Invalid h1 header after parsing\n%s\n", parsed_h1)
   }
   // h6
-  parsed_h6 := ProcessMD("###### h6\n")
-  if parsed_h6 != surroundArticle("\n<h6>h6</h6>\n") {
+  parsed_h6 := processMD("###### h6\n")
+  if parsed_h6 != surroundArticle("\n<h6 id=\"h6\">h6</h6>\n") {
     t.Fatalf("ERROR:: Invalid h6 header after parsing\n%s\n", parsed_h6)
   }
-  // multiple # chars
-  multi_h := ProcessMD("## h2 with # # and ###\n")
-  if multi_h != surroundArticle("\n<h2>h2 with # # and ###</h2>\n") {
+  // multiple # chars, the trailing ones are a closing sequence
+  multi_h := processMD("## h2 with # # and ###\n")
+  if multi_h != surroundArticle("\n<h2 id=\"h2-with-and\">h2 with # # and</h2>\n") {
     t.Fatalf("ERROR:: Invalid header with multiple # after parsing\n%s\n", multi_h)
   }
 }
 
-func _TestHeadingsIncorrect(t* testing.T) {
+func TestHeadingsIncorrect(t* testing.T) {
   fmt.Println("TEST:: Running TestHeadingsIncorrect")
   // no space after header tag
-  parsed_h1 := ProcessMD("#h1\n")
-  if parsed_h1 != surroundArticle("\n<p>#h1</p>\n") {
+  parsed_h1 := processMD("#h1\n")
+  if parsed_h1 != surroundArticle("\n<p>#h1\n</p>\n") {
     t.Fatalf("ERROR:: Unexpected handling of invalid h1\n%s\n", parsed_h1)
   }
-  parsed_h1i := ProcessMD("#h1 actually *italics*\n")
-  if parsed_h1i != surroundArticle("\n<p>#h1 actually <i>italics</i></p>\n") {
+  parsed_h1i := processMD("#h1 actually *italics*\n")
+  if parsed_h1i != surroundArticle("\n<p>#h1 actually <i>italics</i>\n</p>\n") {
     t.Fatalf("ERROR:: Unexpected handling of invalid h1\n%s\n", parsed_h1i)
   }
+  parsed_h7 := processMD("####### h7\n")
+  if parsed_h7 != surroundArticle("\n<p>####### h7\n</p>\n") {
+    t.Fatalf("ERROR:: Unexpected handling of h7\n%s\n", parsed_h7)
+  }
+  // a `#` in the middle of a line is never a heading
+  mid_line := processMD("written in C# and F#")
+  if mid_line != surroundArticlePara("written in C# and F#") {
+    t.Fatalf("ERROR:: Unexpected handling of # inside a paragraph\n%s\n", mid_line)
+  }
 }
 
 func TestParagraph(t* testing.T) {
   fmt.Println("TEST:: Running TestParagraph")
-  para := ProcessMD("test para")
+  para := processMD("test para")
   valid_str := surroundArticle("\n<p>test para</p>\n")
   if para != valid_str {
     t.Fatalf("ERROR:: Invalid parsing of paragraph\n%s\n", para)
   }
-  para = ProcessMD("test para1\n\ntest para2")
+  para = processMD("test para1\n\ntest para2")
   valid_str_body := `
 <p>test para1
 </p>
@@ -68,55 +86,1401 @@ func TestParagraph(t* testing.T) {
   }
 }
 
-func _TestStylingsV1(t* testing.T) {
+func TestStylingsV1(t* testing.T) {
   fmt.Println("TEST:: Running TestStylingsV1")
-  italic := ProcessMD("*italic text*")
+  italic := processMD("*italic text*")
   valid_str := surroundArticlePara("<i>italic text</i>")
   if italic != valid_str {
     t.Fatalf("ERROR:: Invalid parsing of italic text\n%s\n", italic)
   }
-  bold := ProcessMD("**bold text**")
+  bold := processMD("**bold text**")
   valid_str = surroundArticlePara("<b>bold text</b>")
   if bold != valid_str {
     t.Fatalf("ERROR:: Invalid parsing of bold text\n%s\n", bold)
   }
-  italicBold := ProcessMD("***italic bold text***")
+  italicBold := processMD("***italic bold text***")
   valid_str = surroundArticlePara("<i><b>italic bold text</b></i>")
   if italicBold != valid_str {
     t.Fatalf("ERROR:: Invalid parsing of italic bold text\n%s\n", italicBold)
   }
 }
 
-func _TestStylingsIncorrect(t* testing.T) {
+func TestStylingsIncorrect(t* testing.T) {
   fmt.Println("TEST:: Running TestStylingsIncorrect")
-  ivItalic := ProcessMD("* italic*")
-  if ivItalic != surroundArticlePara("* italic*") {
+  // three of these cases expect something else than when this test was disabled. the old
+  // expectations can not hold together with lists and the CommonMark delimiter run rules,
+  // which are asked for as well, so the results of those rules are tested instead:
+  // - `* italic*` was text, a `*` followed by a space at the start of a line is a list item
+  // - `*italic\n*` was `<i>italic\n</i>`, a `*` after whitespace can not close and the
+  //   newline counts as whitespace, so both stay text
+  // - `**bold*` was text, the closer uses one `*` of the opener and the other one is text
+  ivItalic := processMD("* italic*")
+  if ivItalic != surroundArticle("\n<ul>\n<li>italic*</li>\n</ul>\n") {
     t.Fatalf("ERROR:: Invalid handling of invalid italic\n%s\n", ivItalic)
   }
-  ivItalicNl := ProcessMD("*italic\n*")
-  if ivItalicNl != surroundArticlePara("<i>italic\n</i>") {
+  ivItalicNl := processMD("*italic\n*")
+  if ivItalicNl != surroundArticlePara("*italic\n*") {
     t.Fatalf("ERROR:: Invalid handling of invalid italic with newline\n%s\n", ivItalicNl)
   }
-  ivBold := ProcessMD("**bold*")
-  if ivBold != surroundArticlePara("**bold*") {
+  ivBold := processMD("**bold*")
+  if ivBold != surroundArticlePara("*<i>bold</i>") {
     t.Fatalf("ERROR:: Invalid handling of invalid bold\n%s\n", ivBold)
   }
-  ivItalicBold := ProcessMD("***Italic Bold *")
+  ivItalicBold := processMD("***Italic Bold *")
   if ivItalicBold != surroundArticlePara("***Italic Bold *") {
     t.Fatalf("ERROR:: Invalid handling of invalid italic bold\n%s\n", ivItalicBold)
   }
 }
 
+func TestEmphasis(t* testing.T) {
+  fmt.Println("TEST:: Running TestEmphasis")
+  cases := [][]string{
+    {"_italic_ and __bold__ and ___both___", "<i>italic</i> and <b>bold</b> and <i><b>both</b></i>"},
+    {"***a** b*", "<i><b>a</b> b</i>"},
+    {"*a **b***", "<i>a <b>b</b></i>"},
+    {"**a *b* c**", "<b>a <i>b</i> c</b>"},
+    {"snake_case_name and 2*3*4", "snake_case_name and 2<i>3</i>4"},
+    {"foo*bar* and foo_bar_", "foo<i>bar</i> and foo_bar_"},
+    {"*(*a*)*", "<i>(<i>a</i>)</i>"},
+    {"_a *b_ c*", "<i>a *b</i> c*"},
+    {"*a_ and __a*", "<i>a_ and __a</i>"},
+    {"*a_ and _a*", "<i>a_ and _a</i>"},
+    {"_a* and *a_", "<i>a* and *a</i>"},
+    {"*foo**bar**baz*", "<i>foo<b>bar</b>baz</i>"},
+    {"*foo**bar*", "<i>foo**bar</i>"},
+    {"a * b * c", "a * b * c"},
+    {"\\*not\\*", "*not*"},
+    {"\\\\*a*", "\\<i>a</i>"},
+  }
+  for _, c := range cases {
+    out := processMD(c[0])
+    if out != surroundArticlePara(c[1]) {
+      t.Fatalf("ERROR:: Invalid emphasis for %q\n%s\n", c[0], out)
+    }
+  }
+}
+
 func _TestLineBreak(t* testing.T) {
   fmt.Println("TEST:: Running TestLineBreak")
-  lbSimple := ProcessMD("sample test  ")
+  lbSimple := processMD("sample test  ")
   if lbSimple != surroundArticlePara("sample test <br />") {
     t.Fatalf("ERROR:: Invalid handling of simple line break\n%s\n", lbSimple)
   }
 
-  lbItalic := ProcessMD("*sample test  *")
+  lbItalic := processMD("*sample test  *")
   if lbItalic != surroundArticlePara("<i>sample test <br /></i>") {
     t.Fatalf("ERROR:: Invalid handling of line break in Italic\n%s\n", lbItalic)
   }
 }
 
+
+func TestToc(t* testing.T) {
+  fmt.Println("TEST:: Running TestToc")
+  conf := DefaultConfig()
+  state := processMDConfig("# title\n## a\n### b\n## c\n#### skipped\n### d\n", &conf)
+  if len(state.doc.headings) != 6 || state.doc.headings[2].Level != 3 || state.doc.headings[2].Text != "b" {
+    t.Fatalf("ERROR:: Invalid headings collected for toc\n%v\n", state.doc.headings)
+  }
+  valid_toc := `
+<nav class="toc">
+<ul>
+<li><a href="#a">a</a>
+<ul>
+<li><a href="#b">b</a></li>
+</ul>
+</li>
+<li><a href="#c">c</a>
+<ul>
+<li><a href="#d">d</a></li>
+</ul>
+</li>
+</ul>
+</nav>
+`
+  toc := renderToc(state.doc.headings, conf.Toc)
+  if toc != valid_toc {
+    t.Fatalf("ERROR:: Invalid toc for default levels\n%s\n", toc)
+  }
+  // a deeper first heading with a shallower one after it should not nest
+  toc = renderToc([]tocEntry{{Level: 3, Text: "x"}, {Level: 2, Text: "y"}}, conf.Toc)
+  if toc != "\n<nav class=\"toc\">\n<ul>\n<li>x</li>\n<li>y</li>\n</ul>\n</nav>\n" {
+    t.Fatalf("ERROR:: Invalid toc for decreasing levels\n%s\n", toc)
+  }
+  toc = renderToc(state.doc.headings, TocConfig{MinLevel: 1, MaxLevel: 1})
+  if toc != "\n<nav class=\"toc\">\n<ul>\n<li><a href=\"#title\">title</a></li>\n</ul>\n</nav>\n" {
+    t.Fatalf("ERROR:: Invalid toc for configured levels\n%s\n", toc)
+  }
+}
+
+func TestTocMarker(t* testing.T) {
+  fmt.Println("TEST:: Running TestTocMarker")
+  marker := processMD("intro\n[[toc]]\n## a\n")
+  valid_str := surroundArticle("\n<p>intro\n</p>\n\n<nav class=\"toc\">\n<ul>\n<li><a href=\"#a\">a</a></li>\n</ul>\n</nav>\n\n<h2 id=\"a\">a</h2>\n")
+  if marker != valid_str {
+    t.Fatalf("ERROR:: Invalid toc marker placement\n%s\n", marker)
+  }
+  // the marker is only recognised on its own line
+  inline := processMD("see [[toc]] here")
+  if inline != surroundArticlePara("see [[toc]] here") {
+    t.Fatalf("ERROR:: Invalid handling of inline toc marker\n%s\n", inline)
+  }
+  // html written in the page can not stand in for the toc
+  comment := processMD("<!--ssg:toc-->\n\na \ufdd0toc\ufdd1\n## a\n")
+  if strings.Contains(comment, "<nav") || !strings.Contains(comment, "<!--ssg:toc-->") {
+    t.Fatalf("ERROR:: A placeholder written in the page was replaced by the toc\n%s\n", comment)
+  }
+}
+
+func TestTocTemplate(t* testing.T) {
+  fmt.Println("TEST:: Running TestTocTemplate")
+  conf := DefaultConfig()
+  conf.layout = template.Must(template.New("page").Parse(
+    "<title>{{.Title}}</title>{{.Toc}}{{range .Headings}}[{{.Level}}]{{end}}"))
+  state := processMDConfig("# title\n## a\n", &conf)
+  page, _ := renderPage(state, "fallback")
+  valid_str := "<title>title</title>\n<nav class=\"toc\">\n<ul>\n<li><a href=\"#a\">a</a></li>\n</ul>\n</nav>\n[1][2]"
+  if page != valid_str {
+    t.Fatalf("ERROR:: Invalid toc passed to template\n%s\n", page)
+  }
+}
+
+func TestHeadingIds(t* testing.T) {
+  fmt.Println("TEST:: Running TestHeadingIds")
+  dup := processMD("## Intro\n## Intro\n## Intro-1\n")
+  valid_str := "\n<h2 id=\"intro\">Intro</h2>\n\n<h2 id=\"intro-1\">Intro</h2>\n\n<h2 id=\"intro-1-1\">Intro-1</h2>\n"
+  if dup != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid de-duplication of heading ids\n%s\n", dup)
+  }
+  explicit := processMD("## Custom title {#my-id}\n")
+  if explicit != surroundArticle("\n<h2 id=\"my-id\">Custom title</h2>\n") {
+    t.Fatalf("ERROR:: Invalid handling of explicit heading id\n%s\n", explicit)
+  }
+  // an id with a quote could leave the attribute, it is reported and made from the text instead
+  conf := DefaultConfig()
+  quoted := processMDConfig("[[toc]]\n## Hi {#x\"onmouseover=\"alert(1)}\n", &conf)
+  if strings.Contains(quoted.outStr, "\"onmouseover") || !strings.Contains(quoted.outStr, "<li><a href=\"#hi\">Hi</a></li>") ||
+    !strings.Contains(quoted.outStr, "<h2 id=\"hi\">Hi</h2>") {
+    t.Fatalf("ERROR:: Invalid handling of a heading id with a quote\n%s\n", quoted.outStr)
+  }
+  if diags := quoted.diagnostics(""); len(diags) != 1 || diags[0].Rule != "heading-id" || diags[0].Col != 7 {
+    t.Fatalf("ERROR:: Invalid diagnostics for a heading id with a quote\n%v\n", diags)
+  }
+  conf.Headings.Anchor = "¶"
+  anchor := processMDConfig("# Title\n", &conf).outStr
+  if anchor != surroundArticle("\n<h1 id=\"title\">Title <a class=\"anchor\" href=\"#title\">¶</a></h1>\n") {
+    t.Fatalf("ERROR:: Invalid heading anchor after text\n%s\n", anchor)
+  }
+  conf.Headings.Anchor = "#"
+  conf.Headings.AnchorPosition = "before"
+  anchor = processMDConfig("# Title\n", &conf).outStr
+  if anchor != surroundArticle("\n<h1 id=\"title\"><a class=\"anchor\" href=\"#title\">#</a> Title</h1>\n") {
+    t.Fatalf("ERROR:: Invalid heading anchor before text\n%s\n", anchor)
+  }
+}
+
+func TestSlugify(t* testing.T) {
+  fmt.Println("TEST:: Running TestSlugify")
+  conf := DefaultConfig().Headings.Slug
+  cases := [][]string{
+    {"Hello, World!", "hello-world"},
+    {"  spaced   out  ", "spaced-out"},
+    {"snake_case and kebab-case", "snake-case-and-kebab-case"},
+    {"Café Crème", "café-crème"},
+    {"اردو میں عنوان", "اردو-میں-عنوان"},
+    {"?!", "section"},
+  }
+  for _, c := range cases {
+    if slug := slugify(c[0], conf); slug != c[1] {
+      t.Fatalf("ERROR:: Invalid slug for %q\n%s\n", c[0], slug)
+    }
+  }
+  conf.ASCII = true
+  conf.Separator = "_"
+  if slug := slugify("Café Crème über", conf); slug != "cafe_creme_uber" {
+    t.Fatalf("ERROR:: Invalid ascii slug\n%s\n", slug)
+  }
+  conf.Lowercase = false
+  if slug := slugify("Élan Vital", conf); slug != "Elan_Vital" {
+    t.Fatalf("ERROR:: Invalid ascii slug keeping case\n%s\n", slug)
+  }
+}
+
+func TestHeadingsInline(t* testing.T) {
+  fmt.Println("TEST:: Running TestHeadingsInline")
+  conf := DefaultConfig()
+  state := processMDConfig("## Using **bold** and `code`\n", &conf)
+  valid_str := "\n<h2 id=\"using-bold-and-code\">Using <b>bold</b> and <code>code</code></h2>\n"
+  if state.outStr != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid inline formatting inside heading\n%s\n", state.outStr)
+  }
+  if state.doc.headings[0].Text != "Using bold and code" {
+    t.Fatalf("ERROR:: Invalid toc text for formatted heading\n%s\n", state.doc.headings[0].Text)
+  }
+  linked := processMD("# See [the docs](https://example.com) for `*ptr`\n")
+  valid_str = "\n<h1 id=\"see-the-docs-for-ptr\">See <a href=\"https://example.com\">the docs</a> for <code>*ptr</code></h1>\n"
+  if linked != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid link and code span inside heading\n%s\n", linked)
+  }
+  escaped := processMD("## \\*not italic\\* *italic*  \n")
+  valid_str = "\n<h2 id=\"not-italic-italic\">*not italic* <i>italic</i></h2>\n"
+  if escaped != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid escapes inside heading\n%s\n", escaped)
+  }
+}
+
+func TestInline(t* testing.T) {
+  fmt.Println("TEST:: Running TestInline")
+  code := processMD("some `code` and `` a`b `` and `open")
+  if code != surroundArticlePara("some <code>code</code> and <code>a`b</code> and `open") {
+    t.Fatalf("ERROR:: Invalid parsing of code spans\n%s\n", code)
+  }
+  link := processMD("[a *b*](./page.html \"Title\") and [not a link] (x)")
+  valid_str := "<a href=\"./page.html\" title=\"Title\">a <i>b</i></a> and [not a link] (x)"
+  if link != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of links\n%s\n", link)
+  }
+  code = processMD("*`*not closed`*")
+  if code != surroundArticlePara("<i><code>*not closed</code></i>") {
+    t.Fatalf("ERROR:: Invalid parsing of code inside italics\n%s\n", code)
+  }
+}
+
+func TestHeadingsClosingHashes(t* testing.T) {
+  fmt.Println("TEST:: Running TestHeadingsClosingHashes")
+  cases := [][]string{
+    {"## Title ##\n", "\n<h2 id=\"title\">Title</h2>\n"},
+    {"# Title #####   \n", "\n<h1 id=\"title\">Title</h1>\n"},
+    {"## C#\n", "\n<h2 id=\"c\">C#</h2>\n"},
+    {"### foo \\###\n", "\n<h3 id=\"foo\">foo ###</h3>\n"},
+    {"## Title {#custom} ##\n", "\n<h2 id=\"custom\">Title</h2>\n"},
+    {"   # indented\n", "\n<h1 id=\"indented\">indented</h1>\n"},
+    {"# no newline", "\n<h1 id=\"no-newline\">no newline</h1>\n"},
+    {"#\n", "\n<h1 id=\"section\"></h1>\n"},
+  }
+  for _, c := range cases {
+    parsed := processMD(c[0])
+    if parsed != surroundArticle(c[1]) {
+      t.Fatalf("ERROR:: Invalid handling of atx heading %q\n%s\n", c[0], parsed)
+    }
+  }
+}
+
+func TestHeadingsSetext(t* testing.T) {
+  fmt.Println("TEST:: Running TestHeadingsSetext")
+  h1 := processMD("Title\n=====\n")
+  if h1 != surroundArticle("\n<h1 id=\"title\">Title</h1>\n") {
+    t.Fatalf("ERROR:: Invalid setext h1\n%s\n", h1)
+  }
+  h2 := processMD("multi *line*\ntitle\n--- \ntext")
+  valid_str := "\n<h2 id=\"multi-line-title\">multi <i>line</i>\ntitle</h2>\n\n<p>text</p>\n"
+  if h2 != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid multi line setext h2\n%s\n", h2)
+  }
+  // closing hashes only belong to atx headings
+  hashes := processMD("C #\n==\n")
+  if hashes != surroundArticle("\n<h1 id=\"c\">C #</h1>\n") {
+    t.Fatalf("ERROR:: Invalid setext heading ending in #\n%s\n", hashes)
+  }
+  // spaces inside the underline make it a thematic break, not a heading
+  brk := processMD("Title\n- - -\n")
+  if strings.Contains(brk, "<h2") {
+    t.Fatalf("ERROR:: Thematic break taken as setext underline\n%s\n", brk)
+  }
+  // a list item is not an underline either
+  item := processMD("Title\n- item\n")
+  if strings.Contains(item, "<h2") {
+    t.Fatalf("ERROR:: List item taken as setext underline\n%s\n", item)
+  }
+  // without a paragraph above there is nothing to underline
+  alone := processMD("===\n")
+  if alone != surroundArticle("\n<p>===\n</p>\n") {
+    t.Fatalf("ERROR:: Invalid handling of underline without paragraph\n%s\n", alone)
+  }
+}
+
+func TestBlockquote(t* testing.T) {
+  fmt.Println("TEST:: Running TestBlockquote")
+  quote := processMD("> quoted *text*\n> on two lines\n\nafter")
+  valid_str := "\n<blockquote>\n<p>quoted <i>text</i>\non two lines</p>\n</blockquote>\n\n<p>after</p>\n"
+  if quote != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of blockquote\n%s\n", quote)
+  }
+  nested := processMD("> outer\n>\n> > inner\n")
+  valid_str = "\n<blockquote>\n<p>outer\n</p>\n\n<blockquote>\n<p>inner</p>\n</blockquote>\n</blockquote>\n"
+  if nested != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of nested blockquote\n%s\n", nested)
+  }
+  lazy := processMD("> > lazy\ncontinuation\n> line\n")
+  valid_str = "\n<blockquote>\n<blockquote>\n<p>lazy\ncontinuation\nline</p>\n</blockquote>\n</blockquote>\n"
+  if lazy != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid lazy continuation of blockquote\n%s\n", lazy)
+  }
+  // a lazy line can not turn the quoted paragraph into a heading
+  underline := processMD("> text\n===\n")
+  if underline != surroundArticle("\n<blockquote>\n<p>text\n===</p>\n</blockquote>\n") {
+    t.Fatalf("ERROR:: Invalid lazy setext underline in blockquote\n%s\n", underline)
+  }
+  blocks := processMD("> ## Heading\n> - one\n> - two\n>\n> ```go\n> x := 1\n> ```\n")
+  valid_str = "\n<blockquote>\n<h2 id=\"heading\">Heading</h2>\n\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n" +
+    "\n<pre><code class=\"language-go\">x := 1\n</code></pre>\n</blockquote>\n"
+  if blocks != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid blocks inside blockquote\n%s\n", blocks)
+  }
+  // code inside the quote can not be continued lazily
+  code := processMD("> ```\n> code\nnot code\n")
+  valid_str = "\n<blockquote>\n<pre><code>code\n</code></pre>\n</blockquote>\n\n<p>not code\n</p>\n"
+  if code != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid lazy line after code in blockquote\n%s\n", code)
+  }
+}
+
+func TestLists(t* testing.T) {
+  fmt.Println("TEST:: Running TestLists")
+  tight := processMD("- one\n- *two*\n  - nested\n- three\n")
+  valid_str := "\n<ul>\n<li>one</li>\n<li><i>two</i>\n<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>three</li>\n</ul>\n"
+  if tight != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of tight list\n%s\n", tight)
+  }
+  loose := processMD("1. one\n\n2. two\n")
+  valid_str = "\n<ol>\n<li>\n<p>one</p>\n</li>\n<li>\n<p>two</p>\n</li>\n</ol>\n"
+  if loose != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of loose list\n%s\n", loose)
+  }
+  start := processMD("3) three\n4) four")
+  if start != surroundArticle("\n<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of ordered list start\n%s\n", start)
+  }
+  // only an ordered list starting at 1 can end a paragraph
+  para := processMD("in 1999\n2. was not a list\n")
+  if para != surroundArticle("\n<p>in 1999\n2. was not a list\n</p>\n") {
+    t.Fatalf("ERROR:: Invalid ordered list interrupting a paragraph\n%s\n", para)
+  }
+  markers := processMD("- a\n+ b\n")
+  if markers != surroundArticle("\n<ul>\n<li>a</li>\n</ul>\n\n<ul>\n<li>b</li>\n</ul>\n") {
+    t.Fatalf("ERROR:: Invalid handling of changing list markers\n%s\n", markers)
+  }
+  // an empty line between two blocks of an item makes the list loose, even when the second is indented
+  blocks := processMD("- a\n- b\n\n    code")
+  valid_str = "\n<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b\n</p>\n\n<p>code</p>\n</li>\n</ul>\n"
+  if blocks != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid looseness of an item with two paragraphs\n%s\n", blocks)
+  }
+  code := processMD("- a\n\n      indented code")
+  valid_str = "\n<ul>\n<li>\n<p>a\n</p>\n\n<pre><code>indented code\n</code></pre>\n</li>\n</ul>\n"
+  if code != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid looseness of an item with indented code\n%s\n", code)
+  }
+  // the empty line inside of a nested list does not make the outer list loose
+  nested := processMD("- a\n  - b\n\n    c\n- d")
+  valid_str = "\n<ul>\n<li>a\n<ul>\n<li>\n<p>b\n</p>\n\n<p>c</p>\n</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n"
+  if nested != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid looseness of a list with a nested list\n%s\n", nested)
+  }
+}
+
+func TestCodeBlocks(t* testing.T) {
+  fmt.Println("TEST:: Running TestCodeBlocks")
+  fenced := processMD("```go\nfunc a() *int {\n  return nil // <nil>\n}\n```\n")
+  valid_str := "\n<pre><code class=\"language-go\">func a() *int {\n  return nil // &lt;nil&gt;\n}\n</code></pre>\n"
+  if fenced != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of fenced code\n%s\n", fenced)
+  }
+  tilde := processMD("~~~~\n```\n~~~\n~~~~")
+  if tilde != surroundArticle("\n<pre><code>```\n~~~\n</code></pre>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of tilde fenced code\n%s\n", tilde)
+  }
+  // only spaces and tabs split the info string, other whitespace is part of the word
+  nbsp := processMD("```\u00a0\ncode\n```\n")
+  if nbsp != surroundArticle("\n<pre><code class=\"language-\u00a0\">code\n</code></pre>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of an info string of unicode whitespace\n%s\n", nbsp)
+  }
+  if vtab := processMD("```\v"); vtab != surroundArticle("\n<pre><code class=\"language-\v\"></code></pre>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of an info string of a vertical tab\n%s\n", vtab)
+  }
+  words := processMD("```\vgo\trun extra\n```\n")
+  if words != surroundArticle("\n<pre><code class=\"language-\vgo\"></code></pre>\n") {
+    t.Fatalf("ERROR:: Invalid language of an info string\n%s\n", words)
+  }
+  indented := processMD("    code\n\n    more\n\ntext")
+  if indented != surroundArticle("\n<pre><code>code\n\nmore\n</code></pre>\n\n<p>text</p>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of indented code\n%s\n", indented)
+  }
+  // indentation inside a paragraph is just more of the paragraph
+  para := processMD("text\n    more text")
+  if para != surroundArticlePara("text\nmore text") {
+    t.Fatalf("ERROR:: Invalid handling of indented paragraph line\n%s\n", para)
+  }
+}
+
+func TestTables(t* testing.T) {
+  fmt.Println("TEST:: Running TestTables")
+  table := processMD("| Name | Score |\n| :--- | ---: |\n| *a* | 1 |\n| b |\n")
+  valid_str := `
+<table>
+<thead>
+<tr>
+<th style="text-align: left">Name</th>
+<th style="text-align: right">Score</th>
+</tr>
+</thead>
+<tbody>
+<tr>
+<td style="text-align: left"><i>a</i></td>
+<td style="text-align: right">1</td>
+</tr>
+<tr>
+<td style="text-align: left">b</td>
+<td style="text-align: right"></td>
+</tr>
+</tbody>
+</table>
+`
+  if table != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of table\n%s\n", table)
+  }
+  // the line above the delimiter row is taken out of the paragraph as the header
+  escaped := processMD("text\na | b\n:-:|--\n`x\\|y` | c \\| d\n\nafter")
+  valid_str = `
+<p>text
+</p>
+
+<table>
+<thead>
+<tr>
+<th style="text-align: center">a</th>
+<th>b</th>
+</tr>
+</thead>
+<tbody>
+<tr>
+<td style="text-align: center"><code>x|y</code></td>
+<td>c | d</td>
+</tr>
+</tbody>
+</table>
+
+<p>after</p>
+`
+  if escaped != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of table with escaped pipes\n%s\n", escaped)
+  }
+  // the header and the delimiter row need the same number of cells
+  mismatch := processMD("| a |\n|---|---|\n")
+  if mismatch != surroundArticlePara("| a |\n|---|---|\n") {
+    t.Fatalf("ERROR:: Invalid handling of mismatched table delimiter\n%s\n", mismatch)
+  }
+}
+
+func TestGridTables(t* testing.T) {
+  fmt.Println("TEST:: Running TestGridTables")
+  table := processMD(`Table: Plans {header-cols=1}
++-------+----------------+
+| Plan  | Limits         |
++=======+=======+========+
+| Free  | none           |
++-------+-------+--------+
+| Team  | - api | **x**  |
+|       | - cli |        |
+|       +-------+--------+
+|       | seats | 10     |
++-------+-------+--------+
+`)
+  valid_str := `
+<table>
+<caption>Plans</caption>
+<thead>
+<tr>
+<th scope="col">Plan</th>
+<th scope="col" colspan="2">Limits</th>
+</tr>
+</thead>
+<tbody>
+<tr>
+<th scope="row">Free</th>
+<td colspan="2">none</td>
+</tr>
+<tr>
+<th scope="row" rowspan="2">Team</th>
+<td>
+<ul>
+<li>api</li>
+<li>cli</li>
+</ul>
+</td>
+<td><b>x</b></td>
+</tr>
+<tr>
+<td>seats</td>
+<td>10</td>
+</tr>
+</tbody>
+</table>
+`
+  if table != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of grid table\n%s\n", table)
+  }
+  // the caption can come after the table as well
+  after := processMD("+---+\n| a |\n|   |\n| b |\n+---+\nTable: after\n")
+  valid_str = "\n<table>\n<caption>after</caption>\n<tbody>\n<tr>\n<td>\n<p>a\n</p>\n\n<p>b</p>\n</td>\n</tr>\n</tbody>\n</table>\n"
+  if after != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of grid table with caption after it\n%s\n", after)
+  }
+  // a broken table is written as text
+  broken := processMD("+---+---+\n| a | b |\n+---+-\n")
+  if broken != surroundArticlePara("+---+---+\n| a | b |\n+---+-\n") {
+    t.Fatalf("ERROR:: Invalid handling of broken grid table\n%s\n", broken)
+  }
+  conf := DefaultConfig()
+  broken_state := processMDConfig("+---+---+\n| a | b |\n+---+-\n", &conf)
+  if diags := broken_state.diagnostics(""); len(diags) != 1 || diags[0].Severity != SeverityWarning {
+    t.Fatalf("ERROR:: A broken grid table is not a warning\n%v\n", diags)
+  }
+  // a border needs a `-` or `=` run for every column, toml front matter is not a table
+  front := processMDConfig("+++\ntitle = \"a\"\n+++\n", &conf)
+  if front.outStr != surroundArticlePara("+++\ntitle = &quot;a&quot;\n+++\n") || len(front.doc.diags) != 0 {
+    t.Fatalf("ERROR:: Invalid handling of front matter fences\n%s\n", front.outStr)
+  }
+}
+
+func TestComponents(t* testing.T) {
+  fmt.Println("TEST:: Running TestComponents")
+  dir := t.TempDir()
+  os.WriteFile(filepath.Join(dir, "card.html"),
+    []byte(`<div class="card" title="{{.Args.title}}">{{.Inner}}</div>`), 0666)
+  os.WriteFile(filepath.Join(dir, "youtube.html"),
+    []byte(`<iframe src="https://www.youtube.com/embed/{{index .Params 0}}"></iframe>`), 0666)
+  conf := DefaultConfig()
+  conf.Components = dir
+
+  block := processMDConfig(":::card title=\"Pricing plans\"\nthe **inner** text\n:::\n", &conf).outStr
+  valid_str := "\n<div class=\"card\" title=\"Pricing plans\">\n<p>the <b>inner</b> text</p>\n</div>\n"
+  if block != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid block component\n%s\n", block)
+  }
+  // a component inside of a component, the `:::` inside of the code block is code
+  nested := processMDConfig("::::card title=a\n:::card title=b\n```\n:::\n```\n:::\n::::\n", &conf).outStr
+  valid_str = "\n<div class=\"card\" title=\"a\">\n<div class=\"card\" title=\"b\">\n<pre><code>:::\n</code></pre>\n</div>\n</div>\n"
+  if nested != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid nested component\n%s\n", nested)
+  }
+  inline := processMDConfig("watch {{< youtube abc123 >}} first\n", &conf).outStr
+  if inline != surroundArticlePara("watch <iframe src=\"https://www.youtube.com/embed/abc123\"></iframe> first\n") {
+    t.Fatalf("ERROR:: Invalid inline component\n%s\n", inline)
+  }
+  // an unknown component keeps its content and is reported where it was written
+  state := processMDConfig("text\n\n:::missing\ninner\n:::\n", &conf)
+  if state.outStr != surroundArticle(surroundPara("text\n") + surroundPara("inner")) {
+    t.Fatalf("ERROR:: Invalid handling of an unknown component\n%s\n", state.outStr)
+  }
+  if len(state.doc.diags) != 1 || state.doc.diags[0].row != 3 || state.doc.diags[0].col != 1 {
+    t.Fatalf("ERROR:: Unknown component not reported at line 3, col 1\n%v\n", state.doc.diags)
+  }
+}
+
+func TestComponentArgs(t* testing.T) {
+  fmt.Println("TEST:: Running TestComponentArgs")
+  data := parseComponentArgs("card", `title="a b" featured size=3 'c d'`)
+  if data.Args["title"] != "a b" || data.Args["size"] != "3" || len(data.Args) != 2 {
+    t.Fatalf("ERROR:: Invalid component arguments\n%v\n", data.Args)
+  }
+  if len(data.Params) != 2 || data.Params[0] != "featured" || data.Params[1] != "c d" {
+    t.Fatalf("ERROR:: Invalid component params\n%v\n", data.Params)
+  }
+}
+
+func TestEscapes(t* testing.T) {
+  fmt.Println("TEST:: Running TestEscapes")
+  text := processMD("a List<T> & b \"q\" &copy; &#123; &#x1F600; &nope; &amp")
+  valid_str := "a List&lt;T&gt; &amp; b &quot;q&quot; &copy; &#123; &#x1F600; &amp;nope; &amp;amp"
+  if text != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid escaping of html characters\n%s\n", text)
+  }
+  escaped := processMD("\\# not a heading \\*not italic\\* \\<b\\> \\\\")
+  if escaped != surroundArticlePara("# not a heading *not italic* &lt;b&gt; \\") {
+    t.Fatalf("ERROR:: Invalid backslash escapes\n%s\n", escaped)
+  }
+  list := processMD("\\- not a list\n\n1\\. not a list either\n")
+  if list != surroundArticle(surroundPara("- not a list\n") + surroundPara("1. not a list either\n")) {
+    t.Fatalf("ERROR:: Invalid backslash escape of block markers\n%s\n", list)
+  }
+  link := processMD("[a](/x\\(1\\) \"say \\\"hi\\\"\")")
+  if link != surroundArticlePara("<a href=\"/x(1)\" title=\"say &quot;hi&quot;\">a</a>") {
+    t.Fatalf("ERROR:: Invalid backslash escapes in a link\n%s\n", link)
+  }
+  conf := DefaultConfig()
+  state := processMDConfig("## a < b\n", &conf)
+  if state.outStr != surroundArticle("\n<h2 id=\"a-b\">a &lt; b</h2>\n") || state.doc.headings[0].Text != "a < b" {
+    t.Fatalf("ERROR:: Invalid escaping of a heading\n%s\n", state.outStr)
+  }
+}
+
+func TestRawHTML(t* testing.T) {
+  fmt.Println("TEST:: Running TestRawHTML")
+  block := processMD("<div class=\"note\">\n*not* markdown\n</div>\n\n*markdown*")
+  if block != surroundArticle("\n<div class=\"note\">\n*not* markdown\n</div>\n" + surroundPara("<i>markdown</i>")) {
+    t.Fatalf("ERROR:: Invalid html block\n%s\n", block)
+  }
+  // kinds 1 to 5 run to their end condition, empty lines included
+  script := processMD("text\n<script>\nlet a = 1\n\nlet b = 2\n</script>\n<!-- a\n\ncomment -->")
+  valid_str := surroundPara("text\n") + "\n<script>\nlet a = 1\n\nlet b = 2\n</script>\n" + "\n<!-- a\n\ncomment -->\n"
+  if script != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid html blocks ending on their closing condition\n%s\n", script)
+  }
+  // a tag alone on its line can not end a paragraph, it is inline html instead
+  custom := processMD("text\n<my-break>\n\n<my-break>\n")
+  if custom != surroundArticle(surroundPara("text\n<my-break>\n") + "\n<my-break>\n") {
+    t.Fatalf("ERROR:: Invalid html block of kind 7\n%s\n", custom)
+  }
+  // a name that html does not have is not a tag
+  unknown := processMD("<break>\n\nList<T> and </T>\n")
+  if unknown != surroundArticle(surroundPara("&lt;break&gt;\n") + surroundPara("List&lt;T&gt; and &lt;/T&gt;\n")) {
+    t.Fatalf("ERROR:: Invalid handling of unknown tags\n%s\n", unknown)
+  }
+  inline := processMD("a <span title='x > y'>b</span> <!-- c --> <a <b> 1 <2")
+  valid_str = "a <span title='x > y'>b</span> <!-- c --> &lt;a <b> 1 &lt;2"
+  if inline != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid inline html\n%s\n", inline)
+  }
+
+  conf := DefaultConfig()
+  conf.HTML = HTMLEscape
+  escaped := processMDConfig("List<T> and <b>bold</b>\n\n<div>\nx\n</div>\n", &conf).outStr
+  valid_str = surroundPara("List&lt;T&gt; and &lt;b&gt;bold&lt;/b&gt;\n") + surroundPara("&lt;div&gt;\nx\n&lt;/div&gt;")
+  if escaped != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid escaping of raw html\n%s\n", escaped)
+  }
+  conf.HTML = HTMLStrip
+  stripped := processMDConfig("List<T> and <b>bold</b>\n\n<div>\nx\n</div>\n", &conf).outStr
+  // `<T>` is not an element of html, so it is text and not stripped
+  if stripped != surroundArticle(surroundPara("List&lt;T&gt; and bold\n")) {
+    t.Fatalf("ERROR:: Invalid stripping of raw html\n%s\n", stripped)
+  }
+}
+
+func TestSanitize(t* testing.T) {
+  fmt.Println("TEST:: Running TestSanitize")
+  conf := DefaultConfig()
+  conf.Sanitize.Enabled = true
+  state := processMDConfig("# Title\n\n<script>alert(1)</script>\n\n" +
+    "a <b onclick=\"x()\">b</b> <span style=\"color: red\">c</span> <!-- note -->\n" +
+    "[link](javascript:alert\\(1\\)) [ok](https://example.com/?a=1&b=2) <blink>d</blink>\n" +
+    "<img src=\" data:image/png;base64,AAA\" alt=\"e\" /> <a href=\"/relative:path\">f</a>\n", &conf)
+  valid_str := "\n<h1 id=\"title\">Title</h1>\n\n\n\n" +
+    "<p>a <b>b</b> <span>c</span> \n" +
+    "<a>link</a> <a href=\"https://example.com/?a=1&amp;b=2\">ok</a> d\n" +
+    "<img alt=\"e\" /> <a href=\"/relative:path\">f</a>\n</p>\n"
+  if state.outStr != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid sanitizing\n%s\n", state.outStr)
+  }
+  if len(state.doc.diags) != 7 {
+    t.Fatalf("ERROR:: Expected 7 removals to be reported, got %d\n%v\n", len(state.doc.diags), state.doc.diags)
+  }
+  if state.doc.diags[1].row != 5 || state.doc.diags[1].col != 3 {
+    t.Fatalf("ERROR:: Removed event handler not reported at line 5, col 3\n%v\n", state.doc.diags[1])
+  }
+  // the markdown itself has to come out the same
+  conf = DefaultConfig()
+  md := "## a *b*\n\n| x | y |\n|:--|--:|\n| `c` | [d](#e) |\n\n```go\n<x>\n```\n"
+  plain := processMDConfig(md, &conf).outStr
+  conf.Sanitize.Enabled = true
+  if sanitized := processMDConfig(md, &conf).outStr; sanitized != plain {
+    t.Fatalf("ERROR:: Sanitizing changed converted markdown\n%s\n", sanitized)
+  }
+  // only the checkboxes of task lists and the alignment of cells get through
+  state = processMDConfig("- [x] done <input type=\"password\"> <input type=\"checkbox\">\n\n" +
+    "<table><tr><td style=\"text-align: center\">a</td><td style=\"position: fixed\">b</td></tr></table>\n", &conf)
+  valid_str = "\n<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" checked=\"\" disabled=\"\" /> done  <input type=\"checkbox\"></li>\n</ul>\n" +
+    "\n<table><tr><td style=\"text-align: center\">a</td><td>b</td></tr></table>\n"
+  if state.outStr != surroundArticle(valid_str) || len(state.doc.diags) != 2 {
+    t.Fatalf("ERROR:: Invalid sanitizing of inputs and styles\n%s\n%v\n", state.outStr, state.doc.diags)
+  }
+  // the toc passed to the template goes through the allowlist as well
+  conf.Sanitize.Tags = slices.DeleteFunc(slices.Clone(conf.Sanitize.Tags), func(tag string) bool { return tag == "nav" })
+  conf.layout = template.Must(template.New("page").Parse("{{.Toc}}"))
+  state = processMDConfig("## Hi {#x\"onmouseover=\"alert(1)}\n", &conf)
+  page, _ := renderPage(state, "toc")
+  if page != "\n\n<ul>\n<li><a href=\"#hi\">Hi</a></li>\n</ul>\n\n" || !strings.Contains(state.outStr, "<h2 id=\"hi\">Hi</h2>") {
+    t.Fatalf("ERROR:: Invalid sanitizing of the toc\n%s\n%s\n", page, state.outStr)
+  }
+  // a tag without its closing tag does not take the rest of the page with it
+  conf.layout = nil
+  state = processMDConfig("<iframe src=x>\n\npara[^1]\n\n[^1]: note\n", &conf)
+  if !strings.Contains(state.outStr, "<p>para") || !strings.Contains(state.outStr, "note") ||
+    !strings.HasSuffix(state.outStr, "</article>") || strings.Contains(state.outStr, "iframe") {
+    t.Fatalf("ERROR:: Invalid sanitizing of an unclosed tag\n%s\n", state.outStr)
+  }
+}
+
+func TestSections(t* testing.T) {
+  fmt.Println("TEST:: Running TestSections")
+  path := filepath.Join(t.TempDir(), "config.json")
+  os.WriteFile(path, []byte(`{
+    "toc": { "max_level": 4 },
+    "sections": {
+      "contrib": { "sanitize": { "enabled": true, "attributes": { "span": ["style"] } } },
+      "contrib/trusted": { "sanitize": { "enabled": false } }
+    }
+  }`), 0666)
+  conf, err := LoadConfig(path)
+  if err != nil {
+    t.Fatalf("ERROR:: Failed to load the config\n%s\n", err)
+  }
+  if conf.section("blog").Sanitize.Enabled || conf.section(".").Sanitize.Enabled {
+    t.Fatalf("ERROR:: Sanitizing enabled outside of its section\n")
+  }
+  contrib := conf.section("contrib/guests")
+  if !contrib.Sanitize.Enabled || contrib.Toc.MaxLevel != 4 || !contrib.Sanitize.allowedAttr("span", "style") {
+    t.Fatalf("ERROR:: Invalid config for a section\n%v\n", contrib)
+  }
+  if conf.Sanitize.allowedAttr("span", "style") {
+    t.Fatalf("ERROR:: A section changed the config it is in\n")
+  }
+  if conf.section("contrib/trusted/x").Sanitize.Enabled {
+    t.Fatalf("ERROR:: The closest section was not used\n")
+  }
+}
+
+func TestExtensions(t* testing.T) {
+  fmt.Println("TEST:: Running TestExtensions")
+  md := "~~old~~ ==new== x^2^ H~2~O ++added++ C++ and a ~~~ b"
+  // all of them are off by default
+  off := processMD(md)
+  if off != surroundArticlePara(md) {
+    t.Fatalf("ERROR:: Extensions used without being turned on\n%s\n", off)
+  }
+  conf := DefaultConfig()
+  conf.Extensions = ExtensionConfig{Strikethrough: true, Highlight: true, Superscript: true, Subscript: true, Insert: true}
+  on := processMDConfig(md, &conf).outStr
+  valid_str := "<del>old</del> <mark>new</mark> x<sup>2</sup> H<sub>2</sub>O <ins>added</ins> C++ and a ~~~ b"
+  if on != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of extensions\n%s\n", on)
+  }
+  // the runs only pair up with runs of the same length, and mix with emphasis
+  mixed := processMDConfig("~~a ~b~ *c*~~ ~~d~", &conf).outStr
+  if mixed != surroundArticlePara("<del>a <sub>b</sub> <i>c</i></del> ~~d~") {
+    t.Fatalf("ERROR:: Invalid nesting of extensions\n%s\n", mixed)
+  }
+  conf.Extensions = ExtensionConfig{Strikethrough: true}
+  only := processMDConfig("~~a~~ ~b~", &conf).outStr
+  if only != surroundArticlePara("<del>a</del> ~b~") {
+    t.Fatalf("ERROR:: Extension used without being turned on\n%s\n", only)
+  }
+}
+
+func TestTaskLists(t* testing.T) {
+  fmt.Println("TEST:: Running TestTaskLists")
+  conf := DefaultConfig()
+  state := processMDConfig("- [x] done\n- [ ] open\n- [X] also done\n- [] not a task\n- [ ]\n", &conf)
+  valid_str := "\n<ul>\n" +
+    "<li class=\"task-list-item\"><input type=\"checkbox\" checked=\"\" disabled=\"\" /> done</li>\n" +
+    "<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"\" /> open</li>\n" +
+    "<li class=\"task-list-item\"><input type=\"checkbox\" checked=\"\" disabled=\"\" /> also done</li>\n" +
+    "<li>[] not a task</li>\n<li>[ ]</li>\n</ul>\n"
+  if state.outStr != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of task lists\n%s\n", state.outStr)
+  }
+  if state.doc.tasks.Done != 2 || state.doc.tasks.Open != 1 || state.doc.tasks.Total() != 3 {
+    t.Fatalf("ERROR:: Invalid task count\n%v\n", state.doc.tasks)
+  }
+  loose := processMD("1. [ ] first\n\n   more\n2. [x] second\n")
+  valid_str = "\n<ol>\n<li class=\"task-list-item\">\n<p><input type=\"checkbox\" disabled=\"\" /> first\n</p>\n\n<p>more</p>\n</li>\n" +
+    "<li class=\"task-list-item\">\n<p><input type=\"checkbox\" checked=\"\" disabled=\"\" /> second</p>\n</li>\n</ol>\n"
+  if loose != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of a loose task list\n%s\n", loose)
+  }
+  conf.layout = template.Must(template.New("page").Parse("{{.Tasks.Done}}/{{.Tasks.Total}}"))
+  if page, _ := renderPage(state, "tasks"); page != "2/3" {
+    t.Fatalf("ERROR:: Invalid task count passed to template\n%s\n", page)
+  }
+}
+
+func TestFootnotes(t* testing.T) {
+  fmt.Println("TEST:: Running TestFootnotes")
+  conf := DefaultConfig()
+  md := "a claim[^src] and[^Note] again[^src].\n\n" +
+    "[^note]: a note with *style*.\n\n" +
+    "[^src]: the source.\n\n    a second paragraph[^note].\n\n" +
+    "[^unused]: never referenced\n\n" +
+    "and [^missing] one\n"
+  state := processMDConfig(md, &conf)
+  valid_str := "\n<p>a claim<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1\">1</a></sup>" +
+    " and<sup class=\"footnote-ref\"><a href=\"#fn-2\" id=\"fnref-2\">2</a></sup>" +
+    " again<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1-2\">1</a></sup>.\n</p>\n" +
+    "\n<p>and [^missing] one\n</p>\n" +
+    "\n<section class=\"footnotes\">\n<ol>\n" +
+    "<li id=\"fn-1\">\n<p>the source.\n</p>\n\n<p>a second paragraph" +
+    "<sup class=\"footnote-ref\"><a href=\"#fn-2\" id=\"fnref-2-2\">2</a></sup>." +
+    " <a href=\"#fnref-1\" class=\"footnote-backref\">↩</a>" +
+    " <a href=\"#fnref-1-2\" class=\"footnote-backref\">↩<sup>2</sup></a></p>\n</li>\n" +
+    "<li id=\"fn-2\">\n<p>a note with <i>style</i>." +
+    " <a href=\"#fnref-2\" class=\"footnote-backref\">↩</a>" +
+    " <a href=\"#fnref-2-2\" class=\"footnote-backref\">↩<sup>2</sup></a></p>\n</li>\n" +
+    "</ol>\n</section>\n"
+  if state.outStr != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of footnotes\n%s\n", state.outStr)
+  }
+  // the undefined reference and the unused definition, with where they are written
+  diags := state.doc.diags
+  if len(diags) != 2 || diags[0].statusCode != parseError || diags[0].row != 11 || diags[0].col != 5 ||
+    diags[1].statusCode != parseWarning || diags[1].row != 9 || diags[1].col != 1 {
+    t.Fatalf("ERROR:: Invalid footnote diagnostics\n%v\n", diags)
+  }
+  // a definition can not end a paragraph
+  para := processMD("text\n[^a]: b\n")
+  if para != surroundArticlePara("text\n[^a]: b\n") {
+    t.Fatalf("ERROR:: Footnote definition ended a paragraph\n%s\n", para)
+  }
+  // html written in the page can not stand in for a reference
+  comment := processMD("text <!--ssg:fnref:7--> more[^a] <!--ssg:fnref:\n\n[^a]: b\n")
+  if !strings.Contains(comment, "text <!--ssg:fnref:7--> more<sup class=\"footnote-ref\">") ||
+    !strings.Contains(comment, "</sup> &lt;!--ssg:fnref:\n") {
+    t.Fatalf("ERROR:: A comment written in the page was taken as a footnote reference\n%s\n", comment)
+  }
+  // the reference is not part of the text and id of a heading
+  conf = DefaultConfig()
+  heading := processMDConfig("## Title[^1]\n\n[^1]: note\n", &conf)
+  if len(heading.doc.headings) != 1 || heading.doc.headings[0].Text != "Title" || heading.doc.headings[0].ID != "title" ||
+    !strings.Contains(heading.outStr, "<h2 id=\"title\">Title<sup class=\"footnote-ref\">") {
+    t.Fatalf("ERROR:: Invalid heading with a footnote reference\n%s\n%v\n", heading.outStr, heading.doc.headings)
+  }
+}
+
+func TestThematicBreaks(t* testing.T) {
+  fmt.Println("TEST:: Running TestThematicBreaks")
+  breaks := processMD("***\n---\n___\n * * *\n-_-\n")
+  valid_str := "\n<hr />\n\n<hr />\n\n<hr />\n\n<hr />\n" + surroundPara("-_-\n")
+  if breaks != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of thematic breaks\n%s\n", breaks)
+  }
+  // a break ends a paragraph and a list, `---` right below a paragraph is a heading instead
+  mixed := processMD("text\n***\n- a\n- - -\nheading\n---\n")
+  valid_str = surroundPara("text\n") + "\n<hr />\n\n<ul>\n<li>a</li>\n</ul>\n\n<hr />\n\n<h2 id=\"heading\">heading</h2>\n"
+  if mixed != surroundArticle(valid_str) {
+    t.Fatalf("ERROR:: Invalid thematic break next to other blocks\n%s\n", mixed)
+  }
+}
+
+func TestBackslashBreak(t* testing.T) {
+  fmt.Println("TEST:: Running TestBackslashBreak")
+  br := processMD("first\\\nsecond\\")
+  if br != surroundArticlePara("first<br />\nsecond\\") {
+    t.Fatalf("ERROR:: Invalid backslash line break\n%s\n", br)
+  }
+  code := processMD("`a\\\nb`")
+  if code != surroundArticlePara("<code>a\\ b</code>") {
+    t.Fatalf("ERROR:: Backslash line break inside of a code span\n%s\n", code)
+  }
+}
+
+func TestAutolinks(t* testing.T) {
+  fmt.Println("TEST:: Running TestAutolinks")
+  auto := processMD("<https://example.com/a?b=1&c=2> <me@example.com> <made-up:x> < not a link> <a@b>")
+  valid_str := "<a href=\"https://example.com/a?b=1&amp;c=2\">https://example.com/a?b=1&amp;c=2</a>" +
+    " <a href=\"mailto:me@example.com\">me@example.com</a> <a href=\"made-up:x\">made-up:x</a>" +
+    " &lt; not a link&gt; <a href=\"mailto:a@b\">a@b</a>"
+  if auto != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid parsing of autolinks\n%s\n", auto)
+  }
+  // bare urls are only linked with the extension
+  md := "see https://example.com/path, www.example.com. and (https://en.wikipedia.org/wiki/Go_(language)) `https://code.com`"
+  if plain := processMD(md); plain != surroundArticlePara("see https://example.com/path, www.example.com. and" +
+    " (https://en.wikipedia.org/wiki/Go_(language)) <code>https://code.com</code>") {
+    t.Fatalf("ERROR:: Bare url linked without the extension\n%s\n", plain)
+  }
+  conf := DefaultConfig()
+  conf.Extensions.Linkify = true
+  linked := processMDConfig(md, &conf).outStr
+  valid_str = "see <a href=\"https://example.com/path\">https://example.com/path</a>," +
+    " <a href=\"http://www.example.com\">www.example.com</a>. and" +
+    " (<a href=\"https://en.wikipedia.org/wiki/Go_(language)\">https://en.wikipedia.org/wiki/Go_(language)</a>)" +
+    " <code>https://code.com</code>"
+  if linked != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid linking of bare urls\n%s\n", linked)
+  }
+  edge := processMDConfig("[www.a.com](x) *https://a.com/b_c* xhttps://a.com www.a_b.c_d &amp;https://a.com/&hellip;", &conf).outStr
+  valid_str = "<a href=\"x\">www.a.com</a> <i><a href=\"https://a.com/b_c\">https://a.com/b_c</a></i>" +
+    " xhttps://a.com www.a_b.c_d &amp;https://a.com/&hellip;"
+  if edge != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid linking of bare urls next to other text\n%s\n", edge)
+  }
+  // an entity in the url is not escaped again
+  entity := processMDConfig("https://a.com/?a=1&amp;b=2 <https://a.com/?a=1&amp;b=2>", &conf).outStr
+  valid_str = "<a href=\"https://a.com/?a=1&amp;b=2\">https://a.com/?a=1&amp;b=2</a>" +
+    " <a href=\"https://a.com/?a=1&amp;b=2\">https://a.com/?a=1&amp;b=2</a>"
+  if entity != surroundArticlePara(valid_str) {
+    t.Fatalf("ERROR:: Invalid linking of urls with entities\n%s\n", entity)
+  }
+}
+
+func TestUnicode(t* testing.T) {
+  fmt.Println("TEST:: Running TestUnicode")
+  heading := processMD("# اُردو زبان\n")
+  if heading != surroundArticle("\n<h1 id=\"اُردو-زبان\">اُردو زبان</h1>\n") {
+    t.Fatalf("ERROR:: Invalid parsing of a non-latin heading\n%s\n", heading)
+  }
+  if n := columns("اُردو"); n != 4 {
+    t.Fatalf("ERROR:: Combining marks counted as columns, got %d\n", n)
+  }
+  if n := columns("🇵🇰👍🏽é"); n != 3 {
+    t.Fatalf("ERROR:: Flags, emoji modifiers or accents counted as columns, got %d\n", n)
+  }
+  // the column of a diagnostic counts characters
+  conf := DefaultConfig()
+  state := processMDConfig("اُردو [^x]\n", &conf)
+  if len(state.doc.diags) != 1 || state.doc.diags[0].row != 1 || state.doc.diags[0].col != 6 {
+    t.Fatalf("ERROR:: Invalid column for a diagnostic after non-latin text\n%v\n", state.doc.diags)
+  }
+  if pos := runeStart("aü", 2); pos != 1 {
+    t.Fatalf("ERROR:: Cut in the middle of a character\n%d\n", pos)
+  }
+  // wide characters take two columns of a grid table, combining marks none
+  grid := processMD("+------+----+\n| 漢字 | اُر |\n+------+----+\n")
+  if grid != surroundArticle("\n<table>\n<tbody>\n<tr>\n<td>漢字</td>\n<td>اُر</td>\n</tr>\n</tbody>\n</table>\n") {
+    t.Fatalf("ERROR:: Invalid grid table with wide characters\n%s\n", grid)
+  }
+}
+
+func TestInput(t* testing.T) {
+  fmt.Println("TEST:: Running TestInput")
+  crlf, _ := readInput([]byte("\xef\xbb\xbf# title\r\nsome\r\ntext\r"), EncodingAuto)
+  if crlf != "# title\nsome\ntext\n" {
+    t.Fatalf("ERROR:: Invalid normalisation of a bom and line endings\n%q\n", crlf)
+  }
+  converted := processMD(crlf)
+  if converted != surroundArticle("\n<h1 id=\"title\">title</h1>\n\n<p>some\ntext\n</p>\n") {
+    t.Fatalf("ERROR:: Carriage returns left in the output\n%s\n", converted)
+  }
+  // utf-16 with a byte order mark, in both byte orders
+  le, encoding := readInput([]byte{0xff, 0xfe, 'h', 0, 0xe9, 0, 'r', 0, 0x3d, 0xd8, 0x00, 0xde}, EncodingAuto)
+  if le != "hér😀" || encoding != EncodingUTF16LE {
+    t.Fatalf("ERROR:: Invalid decoding of utf-16le\n%q %s\n", le, encoding)
+  }
+  be, encoding := readInput([]byte{0xfe, 0xff, 0, 'h', 0, 0xe9}, EncodingAuto)
+  if be != "hé" || encoding != EncodingUTF16BE {
+    t.Fatalf("ERROR:: Invalid decoding of utf-16be\n%q %s\n", be, encoding)
+  }
+  latin, encoding := readInput([]byte("caf\xe9"), EncodingAuto)
+  if latin != "café" || encoding != EncodingLatin1 {
+    t.Fatalf("ERROR:: Latin-1 was not detected\n%q %s\n", latin, encoding)
+  }
+  // a file that is valid utf-8 can still be read as latin-1 when the config says so
+  forced, _ := readInput([]byte("caf\xc3\xa9"), EncodingLatin1)
+  if forced != "cafÃ©" {
+    t.Fatalf("ERROR:: The encoding in the config was not used\n%q\n", forced)
+  }
+  // only the tabs of the indentation are expanded, the ones in the content are kept
+  tabs, _ := readInput([]byte("\tcode\there\n>\tquote\n- a\tb"), EncodingAuto)
+  if tabs != "    code\there\n>   quote\n- a\tb" {
+    t.Fatalf("ERROR:: Invalid expansion of tabs\n%q\n", tabs)
+  }
+  if processMD(tabs) != processMD("\tcode\there\n>\tquote\n- a\tb") {
+    t.Fatalf("ERROR:: Expanding tabs changed the output\n%s\n", processMD(tabs))
+  }
+
+  path := filepath.Join(t.TempDir(), "config.json")
+  os.WriteFile(path, []byte(`{ "sections": { "old/notes.md": { "encoding": "latin-1" } } }`), 0666)
+  conf, err := LoadConfig(path)
+  if err != nil {
+    t.Fatalf("ERROR:: Failed to load the config\n%s\n", err)
+  }
+  if conf.section("old/notes.md").Encoding != EncodingLatin1 || conf.section("old/other.md").Encoding != EncodingAuto {
+    t.Fatalf("ERROR:: Invalid encoding for a single file\n")
+  }
+}
+
+func TestDiagnostics(t* testing.T) {
+  fmt.Println("TEST:: Running TestDiagnostics")
+  conf := DefaultConfig()
+  // the heading inside of the blockquote is reported at its place in the whole file
+  state := processMDConfig("# ok\n\n> quoted\n> ###bad\n\n漢字 [^nope] here\n", &conf)
+  diags := state.diagnostics("posts/a.md")
+  if len(diags) != 2 || diags[0].Line != 4 || diags[0].Col != 5 || diags[0].Severity != SeverityWarning ||
+    diags[1].Line != 6 || diags[1].Col != 4 || diags[1].Severity != SeverityError {
+    t.Fatalf("ERROR:: Invalid location of diagnostics\n%v\n", diags)
+  }
+  if diags[0].Path != "posts/a.md" || diags[0].Source != "> ###bad" || diags[0].Length != 4 {
+    t.Fatalf("ERROR:: Invalid source of a diagnostic\n%v\n", diags[0])
+  }
+
+  var out strings.Builder
+  writeDiagnostic(&out, diags[1], false)
+  // the wide characters before the footnote take two columns each
+  valid_str := "posts/a.md:6:4: error: the footnote `nope` is never defined, it is written as text\n" +
+    "  |\n" +
+    "6 | 漢字 [^nope] here\n" +
+    "  |      ^~~~~~~\n"
+  if out.String() != valid_str {
+    t.Fatalf("ERROR:: Invalid diagnostic output\n%s\n", out.String())
+  }
+  out.Reset()
+  writeDiagnostic(&out, diags[0], true)
+  if !strings.Contains(out.String(), "\x1b[1;33mwarning:\x1b[0m") {
+    t.Fatalf("ERROR:: Warning written without colour\n%q\n", out.String())
+  }
+}
+
+func TestLinkCheck(t* testing.T) {
+  fmt.Println("TEST:: Running TestLinkCheck")
+  dir := t.TempDir()
+  os.WriteFile(filepath.Join(dir, "other.md"), []byte("# other\n"), 0666)
+  os.WriteFile(filepath.Join(dir, "photo.png"), []byte{}, 0666)
+  os.WriteFile(filepath.Join(dir, "a b.md"), []byte{}, 0666)
+  md := "# Title\n\n[a](other.html) [b](other.md#x) [c](photo.png) [d](https://example.com) [e](/root.html)\n" +
+    "[f](missing.html) [g](#title) [h](#nope) [i](<a%20b.md>)\n"
+  conf := DefaultConfig()
+  state := processMDConfig(md, &conf)
+  state.checkLinks(filepath.Join(dir, "page.md"))
+  diags := state.diagnostics("page.md")
+  if len(diags) != 2 {
+    t.Fatalf("ERROR:: Invalid number of broken links\n%v\n", diags)
+  }
+  if diags[0].Rule != "link-broken" || diags[0].Line != 4 || diags[0].Col != 5 || diags[0].EndCol != 17 ||
+    diags[1].Rule != "link-anchor" || diags[1].Col != 35 || diags[1].EndCol != 40 {
+    t.Fatalf("ERROR:: Invalid diagnostics for broken links\n%v\n", diags)
+  }
+}
+
+func TestDiagnosticsFormats(t* testing.T) {
+  fmt.Println("TEST:: Running TestDiagnosticsFormats")
+  diags := []Diagnostic{{Path: "posts/a.md", Line: 2, Col: 1, EndLine: 2, EndCol: 5, Rule: "heading-space", Severity: SeverityWarning, Message: "a <b> message"}}
+  var out strings.Builder
+  writeDiagnosticsJSON(&out, diags)
+  valid_str := `[
+  {
+    "file": "posts/a.md",
+    "line": 2,
+    "column": 1,
+    "end_line": 2,
+    "end_column": 5,
+    "rule": "heading-space",
+    "severity": "warning",
+    "message": "a <b> message"
+  }
+]
+`
+  if out.String() != valid_str {
+    t.Fatalf("ERROR:: Invalid json diagnostics\n%s\n", out.String())
+  }
+  out.Reset()
+  writeDiagnosticsJSON(&out, nil)
+  if out.String() != "[]\n" {
+    t.Fatalf("ERROR:: Invalid json without diagnostics\n%s\n", out.String())
+  }
+  out.Reset()
+  writeDiagnosticsSARIF(&out, diags)
+  sarif := out.String()
+  for _, part := range []string{`"version": "2.1.0"`, `"id": "heading-space"`, `"ruleId": "heading-space"`, `"level": "warning"`,
+    `"uri": "posts/a.md"`, `"startLine": 2`, `"endColumn": 5`, `"text": "a <b> message"`} {
+    if !strings.Contains(sarif, part) {
+      t.Fatalf("ERROR:: SARIF output without %s\n%s\n", part, sarif)
+    }
+  }
+  // SARIF counts the combining marks of a line as columns of their own
+  conf := DefaultConfig()
+  state := processMDConfig("## اُر {#a\"b}\n", &conf)
+  diags = state.diagnostics("a.md")
+  out.Reset()
+  writeDiagnosticsSARIF(&out, diags)
+  if len(diags) != 1 || diags[0].Col != 7 || !strings.Contains(out.String(), `"startColumn": 8`) || !strings.Contains(out.String(), `"endColumn": 14`) {
+    t.Fatalf("ERROR:: Invalid SARIF columns for a line with combining marks\n%v\n%s\n", diags, out.String())
+  }
+}
+
+func TestLint(t* testing.T) {
+  fmt.Println("TEST:: Running TestLint")
+  md := "# Title\n\nSome text with a break  \nright here.\n\n### Skipped\n\n- one\n* two\n  + three\n\n" +
+    "**Not a heading**\n\n**Fine.**\n\n# Second\n\n```\n# not a heading  \n* code\n```\n\n" +
+    strings.Repeat("word ", 25) + "\n\n<!-- lint-disable-next-line line-length -->\n" + strings.Repeat("word ", 25) + "\n"
+  conf := DefaultConfig()
+  state, _ := lintMarkdown(md, &conf, false)
+  rules := []string{}
+  for _, diag := range state.diagnostics("a.md") {
+    rules = append(rules, diag.Rule + ":" + fmt.Sprint(diag.Line) + ":" + fmt.Sprint(diag.Col))
+  }
+  valid := []string{"trailing-space-break:3:23", "heading-increment:6:1", "list-marker-style:9:1", "list-marker-style:10:3",
+    "emphasis-as-heading:12:1", "single-h1:16:1", "line-length:23:101"}
+  if strings.Join(rules, " ") != strings.Join(valid, " ") {
+    t.Fatalf("ERROR:: Invalid lint problems\n%v\n", rules)
+  }
+
+  // fixing only leaves the problems that can not be fixed, `* two` starts a list of its own
+  // and changing it would join the lists
+  state, fixed := lintMarkdown(md, &conf, true)
+  if len(state.doc.diags) != 5 || !strings.Contains(fixed, "a break\\\nright") || !strings.Contains(fixed, "- one\n* two\n  - three\n") {
+    t.Fatalf("ERROR:: Invalid lint fixes\n%s\n", fixed)
+  }
+  if !strings.Contains(fixed, "# not a heading  \n* code\n") {
+    t.Fatalf("ERROR:: Code was fixed\n%s\n", fixed)
+  }
+
+  // rules can be turned off in the config and by comments
+  conf.Lint.Rules = map[string]bool{lintLineLength: false, lintSingleH1: false}
+  state, _ = lintMarkdown("<!-- lint-disable -->\n# a\n\n### b\n<!-- lint-enable heading-increment -->\n\n##### c\n\n# d\n", &conf, false)
+  diags := state.diagnostics("a.md")
+  if len(diags) != 1 || diags[0].Rule != lintHeadingIncrement || diags[0].Line != 7 {
+    t.Fatalf("ERROR:: Rules were not turned off\n%v\n", diags)
+  }
+
+  // two spaces are only a break inside a paragraph
+  conf = DefaultConfig()
+  for _, md := range []string{"Title  \n=====\n", "<div>  \n</div>\n", "text  \n- item\n", "> text  \n\n> more\n"} {
+    if state, fixed := lintMarkdown(md, &conf, true); len(state.doc.diags) != 0 || fixed != md {
+      t.Fatalf("ERROR:: Trailing spaces that are not a break were fixed\n%s\n", fixed)
+    }
+  }
+  if _, fixed := lintMarkdown("> text  \n> more\n", &conf, true); fixed != "> text\\\n> more\n" {
+    t.Fatalf("ERROR:: Break in a blockquote was not fixed\n%s\n", fixed)
+  }
+  // a fix that changes the html is not made, the problem is reported instead
+  for _, md := range []string{"- a\n* b\n", "- a\n+ b\n\n- c\n"} {
+    if state, fixed := lintMarkdown(md, &conf, true); fixed != md || len(state.doc.diags) != 1 || strings.Contains(state.doc.diags[0].statusMessage, "--fix") {
+      t.Fatalf("ERROR:: A fix changed the html\n%s\n", fixed)
+    }
+  }
+
+  // fixed files keep their encoding and line endings
+  dir := t.TempDir()
+  fpath := filepath.Join(dir, "a.md")
+  os.WriteFile(fpath, []byte("Caf\xe9\r\n\r\n- a\r\n\t* b\r\n"), 0666)
+  lintMain([]string{"--src_dir=" + dir, "--fix"})
+  fixed_bytes, _ := os.ReadFile(fpath)
+  if string(fixed_bytes) != "Caf\xe9\r\n\r\n- a\r\n\t- b\r\n" {
+    t.Fatalf("ERROR:: Invalid fixed file\n%q\n", fixed_bytes)
+  }
+}
+
+func TestFormat(t* testing.T) {
+  fmt.Println("TEST:: Running TestFormat")
+  md := "\n\nTitle\n=====\n\nSome _italic_ and __bold__ text, snake_case stays.  \nhere.\n   \n## Heading ##\n\n\n" +
+    "* one\n* two\n\n| a | long header |\n|:-|--:|\n| 漢字 | x \\| y |\n\n```\n* code _stays_   \n```\n"
+  valid_str := "# Title\n\nSome *italic* and **bold** text, snake_case stays.  \nhere.\n\n## Heading\n\n" +
+    "- one\n- two\n\n| a    | long header |\n| :--- | ----------: |\n| 漢字 | x \\| y      |\n\n```\n* code _stays_   \n```\n"
+  conf := DefaultConfig()
+  formatted := formatMarkdown(md, &conf)
+  if formatted != valid_str {
+    t.Fatalf("ERROR:: Invalid formatting\n%s\n", formatted)
+  }
+  if processMD(formatted) != processMD(md) {
+    t.Fatalf("ERROR:: Formatting changed the html\n%s\n", processMD(formatted))
+  }
+  if again := formatMarkdown(formatted, &conf); again != formatted {
+    t.Fatalf("ERROR:: Formatting a formatted page changed it\n%s\n", again)
+  }
+  // a run that is not closed does not stop the runs after it
+  if formatted := formatMarkdown("__a and _b_\n", &conf); formatted != "__a and *b*\n" {
+    t.Fatalf("ERROR:: Emphasis after an unclosed run was not formatted\n%s\n", formatted)
+  }
+  // a different bullet starts a new list, changing it would join the two lists
+  lists := "- a\n* b\n"
+  if formatted := formatMarkdown(lists, &conf); formatted != lists {
+    t.Fatalf("ERROR:: A bullet that starts a list was changed\n%s\n", formatted)
+  }
+
+  // a word that would start a list at the start of a line stays on the line before
+  conf.Fmt.Wrap = 10
+  wrapped := formatMarkdown("a paragraph with words\n# heading\n\naaaaaaaaaa - b\n\n> quoted text is left alone\n", &conf)
+  if wrapped != "a\nparagraph\nwith words\n# heading\n\naaaaaaaaaa -\nb\n\n> quoted text is left alone\n" {
+    t.Fatalf("ERROR:: Invalid wrapping\n%s\n", wrapped)
+  }
+  // lines of only white space that is not a space are not wrapped
+  for _, md := range []string{"para\n\n\u00a0\n", "para\n\n\f\n"} {
+    if wrapped := formatMarkdown(md, &conf); wrapped != md {
+      t.Fatalf("ERROR:: Invalid wrapping of a white space line\n%q\n", wrapped)
+    }
+  }
+  // only the lines of paragraphs move, the rest of the html stays the same
+  md = "Title\n=====\n\nsome words to wrap\n\n    code  \n    lines\n"
+  wrapped = formatMarkdown(md, &conf)
+  if wrapped != "# Title\n\nsome words\nto wrap\n\n    code  \n    lines\n" || flattenLines(processMD(wrapped)) != flattenLines(processMD(md)) {
+    t.Fatalf("ERROR:: Invalid wrapping with other rewrites\n%s\n", wrapped)
+  }
+  // the newlines of code are kept when the html is compared
+  if html := flattenLines("<p>a\nb</p>\n<pre><code>x\ny\n</code></pre>\n"); html != "<p>a b</p> <pre><code>x\ny\n</code></pre> " {
+    t.Fatalf("ERROR:: Invalid html to compare\n%s\n", html)
+  }
+
+  // files keep their encoding and line endings, a formatted file with them is not changed
+  dir := t.TempDir()
+  os.WriteFile(filepath.Join(dir, "a.md"), []byte("# Caf\xe9\r\n\r\n- a\r\n"), 0666)
+  os.WriteFile(filepath.Join(dir, "b.md"), []byte("Caf\xe9\r\n=====\r\n"), 0666)
+  if code := fmtMain([]string{"--src_dir=" + dir, "--check"}); code != 1 {
+    t.Fatalf("ERROR:: Invalid check exit code %d\n", code)
+  }
+  fmtMain([]string{"--src_dir=" + dir})
+  if code := fmtMain([]string{"--src_dir=" + dir, "--check"}); code != 0 {
+    t.Fatalf("ERROR:: Formatted files are not formatted\n")
+  }
+  for name, valid := range map[string]string{"a.md": "# Caf\xe9\r\n\r\n- a\r\n", "b.md": "# Caf\xe9\r\n"} {
+    if file_bytes, _ := os.ReadFile(filepath.Join(dir, name)); string(file_bytes) != valid {
+      t.Fatalf("ERROR:: Invalid formatted file %s\n%q\n", name, file_bytes)
+    }
+  }
+}
+
+func TestLSP(t* testing.T) {
+  fmt.Println("TEST:: Running TestLSP")
+  dir := t.TempDir()
+  os.WriteFile(filepath.Join(dir, "other.md"), []byte("# Other\n\n## Part two\n"), 0666)
+  os.Mkdir(filepath.Join(dir, "posts"), 0777)
+  os.WriteFile(filepath.Join(dir, "posts", "post.md"), []byte("# Post\n"), 0666)
+  uri := pathToURI(filepath.Join(dir, "page.md"))
+  md := "# Title\n\n## Sub\n\nSee [o](other.md#part-two), a note[^n] and [ref][r]. [x](#sub) [y](\n\n[^n]: the note\n[r]: https://example.com\n\n###bad\n"
+
+  var in strings.Builder
+  send := func(id int, method string, params any) {
+    msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+    if id > 0 {
+      msg["id"] = id
+    }
+    body, _ := json.Marshal(msg)
+    in.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body))
+  }
+  at := func(line int, character int) map[string]any {
+    return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": map[string]any{"line": line, "character": character}}
+  }
+  send(1, "initialize", map[string]any{"rootUri": pathToURI(dir)})
+  // broken messages get a parse error and the server goes on
+  in.WriteString("Content-Length: 5\r\n\r\n{bad}")
+  in.WriteString("Content-Type: application/json\r\n\r\n")
+  send(0, "textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "text": md}})
+  send(2, "textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}})
+  send(3, "textDocument/definition", at(4, 10))
+  send(4, "textDocument/definition", at(4, 36))
+  send(5, "textDocument/definition", at(4, 45))
+  send(6, "textDocument/definition", at(4, 58))
+  send(7, "textDocument/completion", at(4, 67))
+  send(8, "unknown/method", nil)
+  send(9, "shutdown", nil)
+  send(0, "exit", nil)
+
+  var out strings.Builder
+  conf := DefaultConfig()
+  server := lspServer{in: bufio.NewReader(strings.NewReader(in.String())), out: &out, conf: &conf, docs: map[string]string{}}
+  if code := server.serve(); code != 0 {
+    t.Fatalf("ERROR:: Invalid exit code of the server\n%d\n", code)
+  }
+
+  // the replies by their id and the last diagnostics that were published
+  replies := map[int]string{}
+  var diags []lspDiagnostic
+  for _, framed := range strings.Split(out.String(), "Content-Length: ")[1:] {
+    _, body, _ := strings.Cut(framed, "\r\n\r\n")
+    var msg struct {
+      ID     int    `json:"id"`
+      Method string `json:"method"`
+      Params struct {
+        Diagnostics []lspDiagnostic `json:"diagnostics"`
+      } `json:"params"`
+    }
+    json.Unmarshal([]byte(body), &msg)
+    if msg.Method == "textDocument/publishDiagnostics" {
+      diags = msg.Params.Diagnostics
+    } else {
+      replies[msg.ID] = body
+    }
+  }
+
+  if len(diags) != 1 || diags[0].Code != "heading-space" || diags[0].Range.Start != (lspPosition{Line: 9, Character: 2}) || diags[0].Severity != 2 {
+    t.Fatalf("ERROR:: Invalid diagnostics\n%v\n", diags)
+  }
+  if !strings.Contains(replies[2], `"name":"Title"`) || !strings.Contains(replies[2], `"children":[{"name":"Sub"`) {
+    t.Fatalf("ERROR:: Invalid document symbols\n%s\n", replies[2])
+  }
+  other := pathToURI(filepath.Join(dir, "other.md"))
+  if !strings.Contains(replies[3], `"uri":"` + other + `","range":{"start":{"line":2,"character":0}`) {
+    t.Fatalf("ERROR:: Invalid definition of a link to a page\n%s\n", replies[3])
+  }
+  if !strings.Contains(replies[4], `"start":{"line":6,"character":0}`) {
+    t.Fatalf("ERROR:: Invalid definition of a footnote\n%s\n", replies[4])
+  }
+  if !strings.Contains(replies[5], `"start":{"line":7,"character":0}`) {
+    t.Fatalf("ERROR:: Invalid definition of a reference link\n%s\n", replies[5])
+  }
+  if !strings.Contains(replies[6], `"start":{"line":2,"character":0}`) {
+    t.Fatalf("ERROR:: Invalid definition of a link to a heading\n%s\n", replies[6])
+  }
+  if !strings.Contains(replies[7], `"label":"other.html"`) || !strings.Contains(replies[7], `"label":"posts/post.html"`) {
+    t.Fatalf("ERROR:: Invalid completion\n%s\n", replies[7])
+  }
+  if !strings.Contains(replies[8], `"code":-32601`) {
+    t.Fatalf("ERROR:: Unknown methods need an error\n%s\n", replies[8])
+  }
+  if strings.Count(out.String(), `"code":-32700`) != 2 || !strings.Contains(replies[0], `"id":null`) {
+    t.Fatalf("ERROR:: Broken messages need a parse error\n%s\n", replies[0])
+  }
+}
+
+func TestConvert(t* testing.T) {
+  fmt.Println("TEST:: Running TestConvert")
+  html, diags, err := Convert([]byte("# Title\r\n\r\n###bad\r\n"), Options{})
+  if err != nil {
+    t.Fatalf("ERROR:: Failed to convert\n%s\n", err)
+  }
+  if string(html) != processMD("# Title\n\n###bad\n") {
+    t.Fatalf("ERROR:: Invalid html from Convert\n%s\n", html)
+  }
+  if len(diags) != 1 || diags[0].Rule != "heading-space" || diags[0].Line != 3 {
+    t.Fatalf("ERROR:: Invalid diagnostics from Convert\n%v\n", diags)
+  }
+
+  conf := DefaultConfig()
+  conf.HTML = "unknown"
+  if _, _, err := Convert([]byte("text"), Options{Config: &conf}); err == nil {
+    t.Fatalf("ERROR:: A bad config was used to convert\n")
+  }
+  conf = DefaultConfig()
+  conf.Template = filepath.Join(t.TempDir(), "missing.html")
+  if _, _, err := Convert([]byte("text"), Options{Config: &conf}); err == nil {
+    t.Fatalf("ERROR:: A missing template was used to convert\n")
+  }
+}
+
+func TestBuild(t* testing.T) {
+  fmt.Println("TEST:: Running TestBuild")
+  src := t.TempDir()
+  dst := filepath.Join(t.TempDir(), "site")
+  os.Mkdir(filepath.Join(src, "posts"), 0777)
+  os.WriteFile(filepath.Join(src, "index.md"), []byte("# Home\n\n[post](posts/post.html) [gone](gone.html)\n"), 0666)
+  os.WriteFile(filepath.Join(src, "posts", "post.md"), []byte("## Post\n"), 0666)
+  os.WriteFile(filepath.Join(src, "style.css"), []byte("p {}"), 0666)
+  diags, err := Build(context.Background(), SiteOptions{SrcDir: src, DstDir: dst})
+  if err != nil {
+    t.Fatalf("ERROR:: Failed to build\n%s\n", err)
+  }
+  if len(diags) != 1 || diags[0].Rule != "link-broken" {
+    t.Fatalf("ERROR:: Invalid diagnostics from Build\n%v\n", diags)
+  }
+  post, _ := os.ReadFile(filepath.Join(dst, "posts", "post.html"))
+  css, _ := os.ReadFile(filepath.Join(dst, "style.css"))
+  if string(post) != processMD("## Post\n") || string(css) != "p {}" {
+    t.Fatalf("ERROR:: Invalid files from Build\n%s\n", post)
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  if _, err := Build(ctx, SiteOptions{SrcDir: src, DstDir: dst}); err != context.Canceled {
+    t.Fatalf("ERROR:: A build went on after it was stopped\n%v\n", err)
+  }
+}
+
+func TestCommandErrors(t* testing.T) {
+  fmt.Println("TEST:: Running TestCommandErrors")
+  dir := t.TempDir()
+  missing := "--config=" + filepath.Join(dir, "missing.json")
+  // the commands return an exit code instead of stopping the program
+  cases := []struct{ args []string; code int }{
+    {[]string{"--unknown"}, 2},
+    {[]string{"-h"}, 0},
+    {[]string{"--diagnostics-format=xml"}, 1},
+    {[]string{missing}, 1},
+    {[]string{"lint", "--unknown"}, 2},
+    {[]string{"lint", "--src_dir=" + filepath.Join(dir, "none")}, 1},
+    {[]string{"fmt", missing}, 1},
+    {[]string{"lsp", missing}, 1},
+  }
+  for _, c := range cases {
+    if code := Main(c.args); code != c.code {
+      t.Fatalf("ERROR:: Invalid exit code %d for %v\n", code, c.args)
+    }
+  }
+}
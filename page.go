@@ -0,0 +1,53 @@
+package ssg
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// pageData is what the page template gets to work with
+type pageData struct {
+	Title    string
+	Content  template.HTML
+	Toc      template.HTML
+	Headings []tocEntry
+	// done and open task list items, .Tasks.Total counts both
+	Tasks taskCount
+}
+
+// renderPage passes the converted article through the configured template.
+// without a template the article is returned as is
+func renderPage(state parserState, fname string) (string, error) {
+	if state.conf.layout == nil {
+		return state.outStr, nil
+	}
+	toc := renderToc(state.doc.headings, state.conf.Toc)
+	if state.conf.Sanitize.Enabled {
+		// the toc goes through the same allowlist as the article. it is made by the converter,
+		// what would be removed from it is not in the markdown, so it is not reported
+		quiet := state
+		quiet.doc = &docState{}
+		toc = quiet.sanitize(toc)
+	}
+	data := pageData{
+		Title:    fname,
+		Content:  template.HTML(state.outStr),
+		Toc:      template.HTML(toc),
+		Headings: state.doc.headings,
+		Tasks:    state.doc.tasks,
+	}
+	for _, heading := range state.doc.headings {
+		if heading.Level == 1 {
+			data.Title = heading.Text
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	err := state.conf.layout.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template for %s: %w", fname, err)
+	}
+	return buf.String(), nil
+}
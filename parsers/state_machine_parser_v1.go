@@ -1,4 +1,6 @@
-package state_machine_parser_v1;
+package parsers
+
+import "log"
 
 // this will be a sort of a state machine
 // the elements at top have higher priority and
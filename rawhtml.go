@@ -0,0 +1,299 @@
+package ssg
+
+import (
+	"strings"
+)
+
+// raw html follows the CommonMark rules. an html block starts with one of 7 kinds of lines and
+// everything up to its end condition is html, no markdown is parsed inside of it. inline html
+// is an open or closing tag, a comment, a processing instruction, a declaration or CDATA.
+// what is done with the html is up to the "html" config: passthrough, escape or strip
+
+const (
+	HTMLPassthrough = "passthrough"
+	HTMLEscape      = "escape"
+	HTMLStrip       = "strip"
+)
+
+// the tags of html block kind 1, their block only ends on the closing tag
+var htmlRawTags = []string{"pre", "script", "style", "textarea"}
+
+// the tags of html block kind 6, their block ends on an empty line
+var htmlBlockTags = []string{
+	"address", "article", "aside", "base", "basefont", "blockquote", "body", "caption", "center",
+	"col", "colgroup", "dd", "details", "dialog", "dir", "div", "dl", "dt", "fieldset", "figcaption",
+	"figure", "footer", "form", "frame", "frameset", "h1", "h2", "h3", "h4", "h5", "h6", "head",
+	"header", "hr", "html", "iframe", "legend", "li", "link", "main", "menu", "menuitem", "nav",
+	"noframes", "ol", "optgroup", "option", "p", "param", "search", "section", "summary", "table",
+	"tbody", "td", "tfoot", "th", "thead", "title", "tr", "track", "ul",
+}
+
+// the other elements of html, with the ones above they are the tags that are taken as html.
+// `List<T>` is text, a tag name that html does not have is not a tag
+var htmlTagNames = []string{
+	"a", "abbr", "area", "audio", "b", "bdi", "bdo", "br", "button", "canvas", "cite", "code", "data",
+	"datalist", "del", "dfn", "em", "embed", "hgroup", "i", "img", "input", "ins", "kbd", "label", "map",
+	"mark", "math", "meta", "meter", "noscript", "object", "output", "picture", "portal", "progress", "q",
+	"rp", "rt", "ruby", "s", "samp", "select", "slot", "small", "source", "span", "strike", "strong", "sub",
+	"sup", "svg", "template", "time", "tt", "u", "var", "video", "wbr",
+	// obsolete, browsers still know them
+	"acronym", "applet", "big", "blink", "font", "marquee", "nobr",
+}
+
+// isHTMLTagName tells if name is an element of html. custom elements, which need a `-` in
+// their name, are html as well
+func isHTMLTagName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "-") || containsString(htmlTagNames, name) ||
+		containsString(htmlBlockTags, name) || containsString(htmlRawTags, name)
+}
+
+func isASCIILetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isHTMLSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n'
+}
+
+// tagName returns the tag name starting at pos, empty when there is none
+func tagName(str string, pos int) string {
+	if pos >= len(str) || !isASCIILetter(str[pos]) {
+		return ""
+	}
+	end := pos + 1
+	for end < len(str) && (isASCIILetter(str[end]) || (str[end] >= '0' && str[end] <= '9') || str[end] == '-') {
+		end++
+	}
+	return str[pos:end]
+}
+
+// htmlBlockStart returns which kind (1 to 7) of html block the line starts, 0 if it does not.
+// only kinds 1 to 6 can end a paragraph, kind 7 is not checked for when interrupting is true
+func htmlBlockStart(line string, interrupting bool) int {
+	if lineIndent(line) > 3 {
+		return 0
+	}
+	line = strings.TrimLeft(line, " \t")
+	if !strings.HasPrefix(line, "<") {
+		return 0
+	}
+	lower := strings.ToLower(line)
+	name := tagName(lower, 1)
+	for _, tag := range htmlRawTags {
+		if name == tag && (len(line) == len(tag)+1 || isHTMLSpace(line[len(tag)+1]) || line[len(tag)+1] == '>') {
+			return 1
+		}
+	}
+	switch {
+	case strings.HasPrefix(line, "<!--"):
+		return 2
+	case strings.HasPrefix(line, "<?"):
+		return 3
+	case strings.HasPrefix(line, "<![CDATA["):
+		return 5
+	case len(line) > 2 && line[1] == '!' && isASCIILetter(line[2]):
+		return 4
+	}
+	start := 1
+	if strings.HasPrefix(lower, "</") {
+		start = 2
+		name = tagName(lower, start)
+	}
+	if name != "" {
+		rest := lower[start+len(name):]
+		if (rest == "" || isHTMLSpace(rest[0]) || rest[0] == '>' || strings.HasPrefix(rest, "/>")) &&
+			containsString(htmlBlockTags, name) {
+			return 6
+		}
+	}
+	if interrupting {
+		return 0
+	}
+	// a complete tag alone on its line, any tag other than the ones of kind 1
+	end, ok := parseHTMLTag(line, 0)
+	if ok && !containsString(htmlRawTags, name) && isBlank(line[end+1:]) {
+		return 7
+	}
+	return 0
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlBlockEnds checks if the line ends an html block of the given kind
+func htmlBlockEnds(line string, kind int) bool {
+	lower := strings.ToLower(line)
+	switch kind {
+	case 1:
+		for _, tag := range htmlRawTags {
+			if strings.Contains(lower, "</"+tag+">") {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return strings.Contains(line, "-->")
+	case 3:
+		return strings.Contains(line, "?>")
+	case 4:
+		return strings.Contains(line, ">")
+	case 5:
+		return strings.Contains(line, "]]>")
+	}
+	return isBlank(line)
+}
+
+// parseHTMLBlock collects the lines of an html block into res.text. blocks of kind 6 and 7
+// end before the first empty line, the others end on the line holding their end condition
+func parseHTMLBlock(str string, pos int, kind int) (res parsedToken) {
+	res.pos = pos
+	for i := pos; i < len(str); {
+		line, end := lineAt(str, i)
+		if (kind == 6 || kind == 7) && isBlank(line) {
+			break
+		}
+		res.text += line
+		res.pos = end
+		i = end + 1
+		// the end condition can be on the first line as well
+		if kind < 6 && htmlBlockEnds(line, kind) {
+			break
+		}
+	}
+	res.statusCode = parseSuccess
+	return res
+}
+
+// parseHTMLTag checks for an open or closing tag starting at the `<` at pos.
+// returns the position of its `>`
+func parseHTMLTag(str string, pos int) (int, bool) {
+	i := pos + 1
+	if i < len(str) && str[i] == '/' {
+		name := tagName(str, i+1)
+		if !isHTMLTagName(name) {
+			return pos, false
+		}
+		i += 1 + len(name)
+		for i < len(str) && isHTMLSpace(str[i]) {
+			i++
+		}
+		return i, i < len(str) && str[i] == '>'
+	}
+	name := tagName(str, i)
+	if !isHTMLTagName(name) {
+		return pos, false
+	}
+	i += len(name)
+	for {
+		spaces := i
+		for i < len(str) && isHTMLSpace(str[i]) {
+			i++
+		}
+		if i >= len(str) {
+			return pos, false
+		}
+		if str[i] == '>' {
+			return i, true
+		}
+		if strings.HasPrefix(str[i:], "/>") {
+			return i + 1, true
+		}
+		// every attribute needs whitespace in front of it
+		if spaces == i {
+			return pos, false
+		}
+		start := i
+		for i < len(str) && (isASCIILetter(str[i]) || (str[i] >= '0' && str[i] <= '9') || strings.IndexByte("_.:-", str[i]) >= 0) {
+			i++
+		}
+		if start == i || (str[start] >= '0' && str[start] <= '9') || str[start] == '.' || str[start] == '-' {
+			return pos, false
+		}
+		value := i
+		for value < len(str) && isHTMLSpace(str[value]) {
+			value++
+		}
+		if value >= len(str) || str[value] != '=' {
+			continue
+		}
+		i = value + 1
+		for i < len(str) && isHTMLSpace(str[i]) {
+			i++
+		}
+		if i >= len(str) {
+			return pos, false
+		}
+		if str[i] == '"' || str[i] == '\'' {
+			end := strings.IndexByte(str[i+1:], str[i])
+			if end < 0 {
+				return pos, false
+			}
+			i += end + 2
+			continue
+		}
+		start = i
+		for i < len(str) && !isHTMLSpace(str[i]) && strings.IndexByte("\"'=<>`", str[i]) < 0 {
+			i++
+		}
+		if start == i {
+			return pos, false
+		}
+	}
+}
+
+// parseInlineHTML checks for raw html starting at the `<` at pos and returns the position
+// of its last character
+func parseInlineHTML(str string, pos int) (int, bool) {
+	rest := str[pos:]
+	closeAt := func(start int, closer string) (int, bool) {
+		end := strings.Index(rest[start:], closer)
+		if end < 0 {
+			return pos, false
+		}
+		return pos + start + end + len(closer) - 1, true
+	}
+	switch {
+	case strings.HasPrefix(rest, "<!-->") || strings.HasPrefix(rest, "<!--->"):
+		// an empty comment
+		return pos + strings.IndexByte(rest, '>'), true
+	case strings.HasPrefix(rest, "<!--"):
+		return closeAt(4, "-->")
+	case strings.HasPrefix(rest, "<?"):
+		return closeAt(2, "?>")
+	case strings.HasPrefix(rest, "<![CDATA["):
+		return closeAt(9, "]]>")
+	case len(rest) > 2 && rest[1] == '!' && isASCIILetter(rest[2]):
+		return closeAt(2, ">")
+	}
+	return parseHTMLTag(str, pos)
+}
+
+// writeRawHTML applies the html config to raw html found in the document
+func (state *parserState) writeRawHTML(raw string) string {
+	switch state.conf.HTML {
+	case HTMLEscape:
+		return escapeHTML(raw)
+	case HTMLStrip:
+		return ""
+	}
+	return raw
+}
+
+// writeHTMLBlock writes an html block, escaped html is kept readable as a paragraph
+func (state *parserState) writeHTMLBlock(block parsedToken) string {
+	raw := strings.TrimSuffix(block.text, "\n")
+	switch state.conf.HTML {
+	case HTMLEscape:
+		return "\n<p>" + escapeHTML(raw) + "</p>\n"
+	case HTMLStrip:
+		return ""
+	}
+	return "\n" + raw + "\n"
+}
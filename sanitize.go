@@ -0,0 +1,232 @@
+package ssg
+
+import (
+	"html"
+	"strings"
+)
+
+// the sanitizer is a last pass over the converted article for sites that build markdown
+// written by people they do not trust. only the tags and attributes in the allowlist are kept,
+// urls can only use the allowed schemes and anything else is removed and reported.
+// the text in between tags is already escaped by the parser and is copied as it is
+
+// SanitizeConfig is the allowlist used when sanitizing. attributes are listed per tag,
+// the ones under "*" are allowed on every tag
+type SanitizeConfig struct {
+	Enabled    bool                `json:"enabled"`
+	Tags       []string            `json:"tags"`
+	Attributes map[string][]string `json:"attributes"`
+	URLSchemes []string            `json:"url_schemes"`
+}
+
+func defaultSanitizeConfig() SanitizeConfig {
+	return SanitizeConfig{
+		Tags: []string{
+			"a", "abbr", "article", "b", "blockquote", "br", "caption", "code", "del", "details",
+			"div", "em", "figcaption", "figure", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img",
+			"input", "ins", "kbd", "li", "mark", "nav", "ol", "p", "pre", "s", "section", "small", "span",
+			"strong", "sub", "summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "tr", "ul",
+		},
+		Attributes: map[string][]string{
+			"*":   {"class", "id", "title"},
+			"a":   {"href"},
+			"img": {"src", "alt", "width", "height"},
+			// the checkboxes of task lists, other inputs are removed
+			"input": {"type", "checked", "disabled"},
+			"ol":    {"start"},
+			// the style of a cell can only set text-align
+			"th": {"style", "scope", "colspan", "rowspan"},
+			"td": {"style", "colspan", "rowspan"},
+		},
+		URLSchemes: []string{"http", "https", "mailto"},
+	}
+}
+
+// attributes holding a url, their value has to use one of the allowed schemes
+var urlAttributes = []string{"href", "src", "action", "formaction", "cite", "poster", "background", "xlink:href"}
+
+// the content of these tags is dropped together with the tags
+var sanitizeDropContent = []string{"script", "style", "textarea", "iframe", "object", "embed"}
+
+type htmlAttr struct {
+	name  string
+	value string
+}
+
+type htmlTag struct {
+	name    string
+	closing bool
+	attrs   []htmlAttr
+}
+
+// readHTMLTag reads the tag starting at the `<` at pos, the same way parseHTMLTag checks it
+func readHTMLTag(str string, pos int) (htmlTag, int, bool) {
+	var tag htmlTag
+	end, ok := parseHTMLTag(str, pos)
+	if !ok {
+		return tag, pos, false
+	}
+	i := pos + 1
+	if str[i] == '/' {
+		tag.closing = true
+		i++
+	}
+	tag.name = strings.ToLower(tagName(str, i))
+	i += len(tag.name)
+	for i < end {
+		for i < end && (isHTMLSpace(str[i]) || str[i] == '/') {
+			i++
+		}
+		start := i
+		for i < end && !isHTMLSpace(str[i]) && str[i] != '=' && str[i] != '/' {
+			i++
+		}
+		if start == i {
+			break
+		}
+		attr := htmlAttr{name: strings.ToLower(str[start:i])}
+		for i < end && isHTMLSpace(str[i]) {
+			i++
+		}
+		if i < end && str[i] == '=' {
+			i++
+			for i < end && isHTMLSpace(str[i]) {
+				i++
+			}
+			if str[i] == '"' || str[i] == '\'' {
+				quote := strings.IndexByte(str[i+1:], str[i])
+				attr.value = str[i+1 : i+1+quote]
+				i += quote + 2
+			} else {
+				start = i
+				for i < end && !isHTMLSpace(str[i]) {
+					i++
+				}
+				attr.value = str[start:i]
+			}
+		}
+		attr.value = html.UnescapeString(attr.value)
+		tag.attrs = append(tag.attrs, attr)
+	}
+	return tag, end, true
+}
+
+// allowedURL checks the scheme of a url, urls without one are relative and always allowed
+func (conf SanitizeConfig) allowedURL(url string) bool {
+	// browsers ignore whitespace and control characters inside of the scheme
+	url = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, url)
+	colon := strings.IndexByte(url, ':')
+	if colon < 0 || strings.ContainsAny(url[:colon], "/?#") {
+		return true
+	}
+	return containsString(conf.URLSchemes, strings.ToLower(url[:colon]))
+}
+
+func (conf SanitizeConfig) allowedAttr(tag string, attr string) bool {
+	return containsString(conf.Attributes[tag], attr) || containsString(conf.Attributes["*"], attr)
+}
+
+// isCheckbox tells if the input is a checkbox, like the ones of task lists
+func isCheckbox(tag htmlTag) bool {
+	for _, attr := range tag.attrs {
+		if attr.name == "type" {
+			return strings.EqualFold(strings.TrimSpace(attr.value), "checkbox")
+		}
+	}
+	return false
+}
+
+// isTextAlign tells if the style only aligns the text, the way the cells of tables are written
+func isTextAlign(style string) bool {
+	name, value, ok := strings.Cut(strings.TrimSuffix(strings.TrimSpace(style), ";"), ":")
+	value = strings.ToLower(strings.TrimSpace(value))
+	return ok && strings.EqualFold(strings.TrimSpace(name), "text-align") &&
+		(value == "left" || value == "right" || value == "center")
+}
+
+// sanitize removes everything from the html that the allowlist does not have.
+// every removal is reported, at the place in the markdown where it was written
+func (state *parserState) sanitize(str string) string {
+	conf := state.conf.Sanitize
+	var out strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '<' {
+			out.WriteByte(str[i])
+			continue
+		}
+		tag, end, ok := readHTMLTag(str, i)
+		if !ok {
+			end, ok = parseInlineHTML(str, i)
+			if !ok {
+				out.WriteString("&lt;")
+				continue
+			}
+			// comments, declarations and the like
+			state.reportSanitized(str[i:end+1], "removed `"+str[i:end+1]+"`")
+			i = end
+			continue
+		}
+		raw := str[i : end+1]
+		i = end
+		if !containsString(conf.Tags, tag.name) {
+			if tag.closing {
+				continue
+			}
+			state.reportSanitized(raw, "removed the `<"+tag.name+">` tag, it is not allowed")
+			if containsString(sanitizeDropContent, tag.name) {
+				// without a closing tag only the tag is dropped, the rest of the page stays
+				closing := strings.Index(strings.ToLower(str[i:]), "</"+tag.name)
+				if closing >= 0 {
+					if gt := strings.IndexByte(str[i+closing:], '>'); gt >= 0 {
+						i += closing + gt
+					}
+				}
+			}
+			continue
+		}
+		if tag.closing {
+			out.WriteString("</" + tag.name + ">")
+			continue
+		}
+		if tag.name == "input" && !isCheckbox(tag) {
+			state.reportSanitized(raw, "removed the `<input>` tag, only the checkboxes of task lists are allowed")
+			continue
+		}
+		out.WriteString("<" + tag.name)
+		for _, attr := range tag.attrs {
+			switch {
+			case strings.HasPrefix(attr.name, "on"):
+				state.reportSanitized(raw, "removed the `"+attr.name+"` event handler of `<"+tag.name+">`")
+			case !conf.allowedAttr(tag.name, attr.name):
+				state.reportSanitized(raw, "removed the `"+attr.name+"` attribute of `<"+tag.name+">`, it is not allowed")
+			case containsString(urlAttributes, attr.name) && !conf.allowedURL(attr.value):
+				state.reportSanitized(raw, "removed the `"+attr.name+"` of `<"+tag.name+">`, the url scheme is not allowed")
+			case attr.name == "style" && (tag.name == "td" || tag.name == "th") && !isTextAlign(attr.value):
+				state.reportSanitized(raw, "removed the `style` of `<"+tag.name+">`, it can only set text-align")
+			default:
+				out.WriteString(" " + attr.name + "=\"" + escapeHTML(attr.value) + "\"")
+			}
+		}
+		if strings.HasSuffix(raw, "/>") {
+			out.WriteString(" /")
+		}
+		out.WriteString(">")
+	}
+	return out.String()
+}
+
+// reportSanitized reports removed html. the html was already converted, it is looked up in
+// the markdown to find where it came from and falls back to the start of the document
+func (state *parserState) reportSanitized(raw string, message string) {
+	var info parsedToken
+	info.pos = clampFloor(strings.Index(state.inpStr, raw), 0)
+	info.statusCode = parseWarning
+	info.statusMessage = message
+	info.rule = "sanitized"
+	state.report(info)
+}
@@ -0,0 +1,101 @@
+package ssg
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// SARIF is the format code scanning tools read, github turns it into annotations on pull
+// requests. only the parts of SARIF 2.1.0 that are needed for diagnostics are written
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID  string `json:"ruleId"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn"`
+			EndLine     int `json:"endLine"`
+			EndColumn   int `json:"endColumn"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+// codePointColumn turns a column of the line that counts characters into one that counts
+// code points, a character with combining marks is more than one code point
+func codePointColumn(line string, col int) int {
+	chars := graphemes(line)
+	n := min(col-1, len(chars))
+	return utf8.RuneCountInString(strings.Join(chars[:n], "")) + col - n
+}
+
+// writeDiagnosticsSARIF writes the diagnostics as a SARIF log with a single run
+func writeDiagnosticsSARIF(w io.Writer, diags []Diagnostic) error {
+	var run sarifRun
+	run.Tool.Driver.Name = "ssg"
+	run.Tool.Driver.Rules = []sarifRule{}
+	// the columns count characters, they are turned into the code points SARIF counts
+	run.ColumnKind = "unicodeCodePoints"
+	run.Results = []sarifResult{}
+	for _, diag := range diags {
+		if !slices.Contains(run.Tool.Driver.Rules, sarifRule{diag.Rule}) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{diag.Rule})
+		}
+		var result sarifResult
+		result.RuleID = diag.Rule
+		// SARIF uses the same names for the levels
+		result.Level = diag.Severity
+		result.Message.Text = diag.Message
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(diag.Path)
+		region := &location.PhysicalLocation.Region
+		region.StartLine, region.StartColumn = diag.Line, codePointColumn(diag.Source, diag.Col)
+		region.EndLine, region.EndColumn = diag.EndLine, codePointColumn(diag.Source, diag.EndCol)
+		result.Locations = []sarifLocation{location}
+		run.Results = append(run.Results, result)
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(log)
+}
@@ -0,0 +1,123 @@
+package ssg
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SlugConfig decides how heading text is turned into an id
+type SlugConfig struct {
+	// written between words, runs of spaces and punctuation collapse into one
+	Separator string `json:"separator"`
+	Lowercase bool   `json:"lowercase"`
+	// drop everything that is not ascii. accented latin letters are folded to
+	// their base letter first so `Café` still becomes `cafe`
+	ASCII bool `json:"ascii"`
+}
+
+// HeadingConfig controls the ids and self links written on headings
+type HeadingConfig struct {
+	Slug SlugConfig `json:"slug"`
+	// text of the self link, usually `¶` or `#`. no link is written when empty
+	Anchor string `json:"anchor"`
+	// "before" or "after" the heading text
+	AnchorPosition string `json:"anchor_position"`
+}
+
+// used when a heading has nothing left to build a slug from
+const slugFallback = "section"
+
+var latinFold map[rune]string = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ð': "d", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'þ': "th", 'ß': "ss",
+}
+
+// slugify turns heading text into an id. letters, digits and combining marks of
+// every script are kept so that non latin headings still get a readable id
+func slugify(text string, conf SlugConfig) string {
+	var slug strings.Builder
+	pendingSep := false
+	for _, ch := range text {
+		if conf.Lowercase {
+			ch = unicode.ToLower(ch)
+		}
+		keep := ""
+		switch {
+		case ch < unicode.MaxASCII && (unicode.IsLetter(ch) || unicode.IsDigit(ch)):
+			keep = string(ch)
+		case conf.ASCII:
+			folded, ok := latinFold[unicode.ToLower(ch)]
+			if ok && !conf.Lowercase && unicode.IsUpper(ch) {
+				folded = strings.ToUpper(folded[:1]) + folded[1:]
+			}
+			keep = folded
+		case unicode.IsLetter(ch) || unicode.IsDigit(ch) || unicode.IsMark(ch):
+			keep = string(ch)
+		}
+		if keep == "" {
+			if unicode.IsSpace(ch) || ch == '-' || ch == '_' {
+				pendingSep = true
+			}
+			continue
+		}
+		if pendingSep && slug.Len() > 0 {
+			slug.WriteString(conf.Separator)
+		}
+		pendingSep = false
+		slug.WriteString(keep)
+	}
+	if slug.Len() == 0 {
+		return slugFallback
+	}
+	return slug.String()
+}
+
+// slugger hands out unique ids for a single document
+type slugger struct {
+	seen map[string]bool
+}
+
+// unique returns id if it has not been used yet, otherwise the first free `id-N`
+func (s *slugger) unique(id string, sep string) string {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	res := id
+	for n := 1; s.seen[res]; n++ {
+		res = id + sep + strconv.Itoa(n)
+	}
+	s.seen[res] = true
+	return res
+}
+
+// isHeadingID tells if an explicit id only has letters, digits, `-`, `_`, `.` and `:`,
+// the characters that can be written in an attribute and a url fragment as they are
+func isHeadingID(id string) bool {
+	for _, r := range id {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:", r) {
+			return false
+		}
+	}
+	return id != ""
+}
+
+// splitHeadingID pulls an explicit `{#id}` off the end of heading text
+func splitHeadingID(text string) (string, string) {
+	trimmed := strings.TrimRight(text, " ")
+	if !strings.HasSuffix(trimmed, "}") {
+		return text, ""
+	}
+	open := strings.LastIndex(trimmed, "{#")
+	if open < 0 {
+		return text, ""
+	}
+	id := trimmed[open+2 : len(trimmed)-1]
+	if id == "" || strings.ContainsAny(id, " {}") {
+		return text, ""
+	}
+	return strings.TrimRight(trimmed[:open], " "), id
+}
@@ -0,0 +1,91 @@
+// Package ssg converts markdown to html, it is the engine of the ssg command.
+//
+// Convert turns a single markdown document into html, Build converts a whole directory of
+// them into a site the way `ssg --src_dir=... --dst_dir=...` does. both return the warnings
+// and errors found in the markdown as diagnostics, a document with problems still converts.
+// the error is only for what stops the conversion, like a config or template that can not
+// be used or a file that can not be read or written
+package ssg
+
+import (
+	"context"
+	"os"
+)
+
+// Options are the settings of Convert
+type Options struct {
+	// the config, nil is the default one. sections of the config are not looked up,
+	// use the config of the section for the document
+	Config *Config
+	// path of the markdown file, the diagnostics name it and relative links are checked
+	// from its directory. links are not checked without it
+	Path string
+	// title passed to the template when the document has no h1
+	Title string
+}
+
+// SiteOptions are the settings of Build
+type SiteOptions struct {
+	// the directory of the markdown files and the directory the site is written to
+	SrcDir string
+	DstDir string
+	// the config, nil is the default one
+	Config *Config
+}
+
+// Convert converts the markdown in src to html. with a template in the config the html is the
+// whole page, the bare <article> otherwise
+func Convert(src []byte, opts Options) ([]byte, []Diagnostic, error) {
+	conf, err := prepareConfig(opts.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	page, diags, err := convertFile(src, opts.Path, opts.Title, &conf)
+	if err != nil {
+		return nil, diags, err
+	}
+	return []byte(page), diags, nil
+}
+
+// Build converts every file of opts.SrcDir into opts.DstDir, markdown files become html pages
+// and the other files are copied. it stops early when ctx is done
+func Build(ctx context.Context, opts SiteOptions) ([]Diagnostic, error) {
+	conf, err := prepareConfig(opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(opts.DstDir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return process(ctx, opts.SrcDir, opts.DstDir, "", &conf)
+}
+
+// prepareConfig checks a copy of the config, so the caller can use the same config
+// for more than one conversion
+func prepareConfig(conf *Config) (Config, error) {
+	if conf == nil {
+		return LoadConfig("")
+	}
+	prepared := *conf
+	return prepared, prepared.init()
+}
+
+// convertFile converts the markdown file read from path into its page
+func convertFile(data []byte, path string, title string, conf *Config) (string, []Diagnostic, error) {
+	input, encoding := readInput(data, conf.Encoding)
+	state := processMDConfig(input, conf)
+	if conf.Encoding == EncodingAuto && encoding == EncodingLatin1 {
+		var info parsedToken
+		info.statusCode = parseWarning
+		info.statusMessage = "the file is not utf-8, it is read as latin-1"
+		info.rule = "encoding"
+		state.report(info)
+	}
+	if conf.CheckLinks && path != "" {
+		state.checkLinks(path)
+	}
+	diags := state.diagnostics(path)
+	page, err := renderPage(state, title)
+	return page, diags, err
+}
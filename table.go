@@ -0,0 +1,438 @@
+package ssg
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// splitTableRow splits a table row on every `|` that is not escaped. the pipes at
+// the start and the end of the row are optional. `\|` is written as a plain `|`,
+// even inside of a code span, so that a cell can hold one
+func splitTableRow(line string) []string {
+	line = strings.Trim(line, " \t\n")
+	if strings.HasPrefix(line, "|") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	cells := make([]string, 0, 8)
+	cell := ""
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell += "|"
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.Trim(cell, " \t"))
+			cell = ""
+		default:
+			cell += line[i : i+1]
+		}
+	}
+	return append(cells, strings.Trim(cell, " \t"))
+}
+
+// parseTableDelimiter reads the row below the header, `| :--- | :---: | ---: |`.
+// returns the text-align of every column, empty when none was asked for
+func parseTableDelimiter(line string) ([]string, bool) {
+	if lineIndent(line) > 3 || strings.IndexByte(line, '|') < 0 {
+		return nil, false
+	}
+	cells := splitTableRow(line)
+	align := make([]string, len(cells))
+	for i, cell := range cells {
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		dashes := strings.Trim(cell, ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil, false
+		}
+		switch {
+		case left && right:
+			align[i] = "center"
+		case left:
+			align[i] = "left"
+		case right:
+			align[i] = "right"
+		}
+	}
+	return align, true
+}
+
+type parsedTable struct {
+	parsedToken
+	// text-align of every column, empty when none was asked for
+	align  []string
+	header []string
+	rows   [][]string
+}
+
+// parseTable parses a pipe table. header is the line above the delimiter row at pos,
+// it is already read as paragraph text by the time the delimiter row shows up.
+// the table ends on an empty line or on a line that starts another block
+func parseTable(header string, str string, pos int) (res parsedTable) {
+	res.pos = pos
+	delimiter, end := lineAt(str, pos)
+	align, ok := parseTableDelimiter(delimiter)
+	res.header = splitTableRow(header)
+	if !ok || len(res.header) != len(align) {
+		res.statusCode = parseError
+		res.statusMessage = "the delimiter row needs one cell for every header cell"
+		return res
+	}
+	res.align = align
+	res.pos = end
+	for i := end + 1; i < len(str); {
+		line, end := lineAt(str, i)
+		if isBlank(line) || interruptsParagraph(line) {
+			break
+		}
+		// missing cells are left empty and extra cells are dropped
+		cells := splitTableRow(line)
+		row := make([]string, len(align))
+		copy(row, cells)
+		res.rows = append(res.rows, row)
+		res.pos = end
+		i = end + 1
+	}
+	res.statusCode = parseSuccess
+	return res
+}
+
+func (state *parserState) tableCell(tag string, text string, align string) string {
+	out := "<" + tag
+	if align != "" {
+		out += " style=\"text-align: " + align + "\""
+	}
+	return out + ">" + state.parseInline(text) + "</" + tag + ">\n"
+}
+
+func (state *parserState) writeTable(table parsedTable) string {
+	out := "\n<table>\n<thead>\n<tr>\n"
+	for i, cell := range table.header {
+		out += state.tableCell("th", cell, table.align[i])
+	}
+	out += "</tr>\n</thead>\n"
+	if len(table.rows) > 0 {
+		out += "<tbody>\n"
+		for _, row := range table.rows {
+			out += "<tr>\n"
+			for i, cell := range row {
+				out += state.tableCell("td", cell, table.align[i])
+			}
+			out += "</tr>\n"
+		}
+		out += "</tbody>\n"
+	}
+	return out + "</table>\n"
+}
+
+// grid tables are the extended table syntax. every cell is drawn with a border so that
+// cells can span rows and columns and hold more than one line of block content.
+//
+//	Table: Caption of the table {header-cols=1}
+//	+---------+-----------------+
+//	| Name    | Value           |
+//	+=========+========+========+
+//	| spans   | two columns     |
+//	+---------+--------+--------+
+//	| rows    | - a    | b      |
+//	|         | - list |        |
+//	|         +--------+--------+
+//	|         | c      | d      |
+//	+---------+--------+--------+
+//
+// rows above the `=` border are the header. the caption line can be written right
+// before or right after the table.
+
+// the prefix of the caption line of a grid table
+const tableCaption = "Table:"
+
+type gridCell struct {
+	// the position of the border around the cell in the grid
+	top, left, bottom, right int
+	// index of the first row and column the cell is in and how many it spans
+	row, col         int
+	rowspan, colspan int
+	content          string
+}
+
+type parsedGridTable struct {
+	parsedToken
+	cells []gridCell
+	rows  int
+	// rows above the `=` border and columns on the left that are written as headers
+	headerRows int
+	headerCols int
+	caption    string
+	// last character of the lines drawing the table, set even when the table is broken
+	end int
+}
+
+// isGridBorder checks for a line like `+----+----+` or `+====+====+`. every column needs
+// a run of `-` or `=`, so a `+++` front matter fence is not a table
+func isGridBorder(line string) bool {
+	line = strings.TrimRight(line, " \t\n")
+	if lineIndent(line) > 3 {
+		return false
+	}
+	line = strings.TrimLeft(line, " ")
+	if len(line) < 3 || line[0] != '+' || line[len(line)-1] != '+' {
+		return false
+	}
+	for _, run := range strings.Split(line[1:len(line)-1], "+") {
+		if run == "" || (strings.Trim(run, "-") != "" && strings.Trim(run, "=") != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCaption reads `Table: caption {header-cols=N}`
+func parseCaption(line string) (string, int, bool) {
+	line = strings.Trim(line, " \t\n")
+	if !strings.HasPrefix(line, tableCaption) {
+		return "", 0, false
+	}
+	caption := strings.TrimSpace(line[len(tableCaption):])
+	headerCols := 0
+	if open := strings.LastIndex(caption, "{"); open >= 0 && strings.HasSuffix(caption, "}") {
+		for _, attr := range strings.Fields(caption[open+1 : len(caption)-1]) {
+			key, val, _ := strings.Cut(attr, "=")
+			if key == "header-cols" {
+				headerCols, _ = strconv.Atoi(val)
+			}
+		}
+		caption = strings.TrimSpace(caption[:open])
+	}
+	return caption, headerCols, true
+}
+
+// scanCell follows the border of the cell with its top left corner at top, left and
+// returns the bottom right corner. the right border is the first `+` on the top border
+// that has a border going down from it to a `+` that closes the cell
+func scanCell(grid [][]string, top int, left int) (int, int, bool) {
+	width := len(grid[0])
+	for right := left + 1; right < width; right++ {
+		ch := grid[top][right]
+		if ch != "+" {
+			if ch != "-" && ch != "=" {
+				return 0, 0, false
+			}
+			continue
+		}
+		for bottom := top + 1; bottom < len(grid); bottom++ {
+			ch = grid[bottom][right]
+			if ch == "+" && closesCell(grid, top, left, bottom, right) {
+				return bottom, right, true
+			}
+			if ch != "+" && ch != "|" {
+				break
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// closesCell checks the bottom and left borders of a cell
+func closesCell(grid [][]string, top int, left int, bottom int, right int) bool {
+	for c := right - 1; c > left; c-- {
+		ch := grid[bottom][c]
+		if ch != "-" && ch != "=" && ch != "+" {
+			return false
+		}
+	}
+	if grid[bottom][left] != "+" {
+		return false
+	}
+	for r := bottom - 1; r > top; r-- {
+		ch := grid[r][left]
+		if ch != "|" && ch != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// cellContent cuts the text inside the border of a cell out of the grid, with the
+// indentation every line has in common removed
+func cellContent(grid [][]string, cell gridCell) string {
+	lines := make([]string, 0, cell.bottom-cell.top)
+	indent := -1
+	for r := cell.top + 1; r < cell.bottom; r++ {
+		line := strings.TrimRight(strings.Join(grid[r][cell.left+1:cell.right], ""), " ")
+		lines = append(lines, line)
+		if line != "" && (indent < 0 || lineIndent(line) < indent) {
+			indent = lineIndent(line)
+		}
+	}
+	content := ""
+	for _, line := range lines {
+		content += dedent(line, indent) + "\n"
+	}
+	return strings.Trim(content, "\n")
+}
+
+// parseGridTable parses the grid table starting with the border at pos
+func parseGridTable(str string, pos int) (res parsedGridTable) {
+	res.pos = pos
+	// one column of the grid for every character, and two for the wide ones so that
+	// the borders line up with what a monospace editor shows
+	grid := make([][]string, 0, 16)
+	width := 0
+	headerLine := -1
+	for i := pos; i < len(str); {
+		line, end := lineAt(str, i)
+		line = strings.TrimRight(strings.TrimLeft(line, " "), " \t\n")
+		if line == "" || (line[0] != '+' && line[0] != '|') {
+			break
+		}
+		if isGridBorder(line) && strings.IndexByte(line, '=') >= 0 {
+			headerLine = len(grid)
+		}
+		row := make([]string, 0, len(line))
+		for _, char := range graphemes(line) {
+			row = append(row, char)
+			if isWide(char) {
+				row = append(row, "")
+			}
+		}
+		width = max(width, len(row))
+		grid = append(grid, row)
+		res.end = end
+		i = end + 1
+	}
+	if len(grid) < 3 || !isGridBorder(strings.Join(grid[len(grid)-1], "")) {
+		res.statusCode = parseWarning
+		res.statusMessage = "a grid table has to end with a border like `+---+`"
+		res.rule = "grid-table"
+		return res
+	}
+	for i := range grid {
+		// short lines are padded so every line is as wide as the widest one
+		for len(grid[i]) < width {
+			grid[i] = append(grid[i], " ")
+		}
+	}
+
+	// every cell found adds the corners at its top right and bottom left, which are the
+	// top left corners of the cells next to and below it
+	corners := [][2]int{{0, 0}}
+	seen := make(map[[2]int]bool)
+	area := 0
+	rowLines := map[int]bool{}
+	colLines := map[int]bool{}
+	for len(corners) > 0 {
+		corner := corners[0]
+		corners = corners[1:]
+		top, left := corner[0], corner[1]
+		if seen[corner] || top == len(grid)-1 || left == width-1 {
+			continue
+		}
+		seen[corner] = true
+		bottom, right, ok := scanCell(grid, top, left)
+		if !ok {
+			continue
+		}
+		cell := gridCell{top: top, left: left, bottom: bottom, right: right}
+		res.cells = append(res.cells, cell)
+		area += (bottom - top) * (right - left)
+		rowLines[top], rowLines[bottom] = true, true
+		colLines[left], colLines[right] = true, true
+		corners = append(corners, [2]int{top, right}, [2]int{bottom, left})
+	}
+	if area != (len(grid)-1)*(width-1) {
+		res.cells = nil
+		res.statusCode = parseWarning
+		res.statusMessage = "the grid table has a cell with a broken border, every cell needs `+` corners and `|`/`-` borders"
+		res.rule = "grid-table"
+		return res
+	}
+
+	rowIndex := lineIndexes(rowLines)
+	colIndex := lineIndexes(colLines)
+	for i := range res.cells {
+		cell := &res.cells[i]
+		cell.row, cell.col = rowIndex[cell.top], colIndex[cell.left]
+		cell.rowspan = rowIndex[cell.bottom] - cell.row
+		cell.colspan = colIndex[cell.right] - cell.col
+		cell.content = cellContent(grid, *cell)
+	}
+	slices.SortFunc(res.cells, func(a gridCell, b gridCell) int {
+		if a.row != b.row {
+			return a.row - b.row
+		}
+		return a.col - b.col
+	})
+	res.rows = len(rowLines) - 1
+	if headerLine >= 0 {
+		res.headerRows = rowIndex[headerLine]
+	}
+	res.pos = res.end
+	res.statusCode = parseSuccess
+	return res
+}
+
+// lineIndexes numbers the border lines found while scanning the cells
+func lineIndexes(lines map[int]bool) map[int]int {
+	sorted := make([]int, 0, len(lines))
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	slices.Sort(sorted)
+	index := make(map[int]int, len(sorted))
+	for i, line := range sorted {
+		index[line] = i
+	}
+	return index
+}
+
+func (state *parserState) writeGridTable(table parsedGridTable) string {
+	out := "\n<table>\n"
+	if table.caption != "" {
+		out += "<caption>" + state.parseInline(table.caption) + "</caption>\n"
+	}
+	head := ""
+	body := ""
+	next := 0
+	for row := 0; row < table.rows; row++ {
+		// a row can be left without cells of its own when every column is spanned from above
+		tr := "<tr>\n"
+		for ; next < len(table.cells) && table.cells[next].row == row; next++ {
+			cell := table.cells[next]
+			tag := "td"
+			attrs := ""
+			if row < table.headerRows {
+				tag = "th"
+				attrs = " scope=\"col\""
+			} else if cell.col < table.headerCols {
+				tag = "th"
+				attrs = " scope=\"row\""
+			}
+			if cell.colspan > 1 {
+				attrs += " colspan=\"" + strconv.Itoa(cell.colspan) + "\""
+			}
+			if cell.rowspan > 1 {
+				attrs += " rowspan=\"" + strconv.Itoa(cell.rowspan) + "\""
+			}
+			// a cell with a single block is written like an item of a tight list
+			content := state.nested(cell.content, !strings.Contains(cell.content, "\n\n"))
+			tr += "<" + tag + attrs + ">" + content + "</" + tag + ">\n"
+		}
+		tr += "</tr>\n"
+		if row < table.headerRows {
+			head += tr
+		} else {
+			body += tr
+		}
+	}
+	if head != "" {
+		out += "<thead>\n" + head + "</thead>\n"
+	}
+	if body != "" {
+		out += "<tbody>\n" + body + "</tbody>\n"
+	}
+	return out + "</table>\n"
+}
@@ -0,0 +1,94 @@
+package ssg
+
+import "strings"
+
+// marker that can be written on its own line to place the table of contents
+// inside the article
+const tocMarker = "[[toc]]"
+
+// the toc can only be rendered once every heading has been seen, so the
+// marker is first written out as this placeholder and swapped at the end
+const tocPlaceholder = placeholderStart + "toc" + placeholderEnd
+
+// placeholders are written with unicode noncharacters, they are meant for use inside of a
+// program and are taken out of the input, so nothing written in a page turns into one
+const (
+	placeholderStart = "\ufdd0"
+	placeholderEnd   = "\ufdd1"
+)
+
+// removePlaceholders replaces the characters of the placeholders in the input with the
+// replacement character, which takes as many bytes so positions stay the same
+func removePlaceholders(str string) string {
+	return strings.NewReplacer(placeholderStart, "\ufffd", placeholderEnd, "\ufffd").Replace(str)
+}
+
+// tocEntry is a single heading as seen by the table of contents.
+// fields are exported so that page templates can build their own toc
+type tocEntry struct {
+	Level int
+	Text  string
+	ID    string
+	// where the heading starts in the document
+	pos int
+}
+
+// isTocMarker checks if the line starting at pos only holds the toc marker.
+// returns the position of the last character of the line
+func isTocMarker(str string, pos int) (bool, int) {
+	i := pos
+	for i < len(str) && str[i] != '\n' {
+		i++
+	}
+	line := str[pos:i]
+	for len(line) > 0 && line[len(line)-1] == ' ' {
+		line = line[:len(line)-1]
+	}
+	if line != tocMarker {
+		return false, pos
+	}
+	if i == len(str) {
+		i--
+	}
+	return true, i
+}
+
+// renderToc writes the headings as a nested list. a heading that is deeper than
+// the one before it opens a new list inside the previous item, skipped levels
+// (h2 followed directly by h4) only nest once.
+func renderToc(entries []tocEntry, conf TocConfig) string {
+	out := ""
+	// levels of the currently open lists, innermost last
+	levels := make([]int, 0, len(hMap))
+	for _, entry := range entries {
+		if entry.Level < conf.MinLevel || entry.Level > conf.MaxLevel {
+			continue
+		}
+		if len(levels) == 0 {
+			out += "\n<nav class=\"toc\">\n<ul>\n<li>"
+			levels = append(levels, entry.Level)
+		} else if entry.Level > levels[len(levels)-1] {
+			out += "\n<ul>\n<li>"
+			levels = append(levels, entry.Level)
+		} else {
+			for len(levels) > 1 && levels[len(levels)-2] >= entry.Level {
+				out += "</li>\n</ul>\n"
+				levels = levels[:len(levels)-1]
+			}
+			out += "</li>\n<li>"
+			levels[len(levels)-1] = min(levels[len(levels)-1], entry.Level)
+		}
+		if entry.ID != "" {
+			out += "<a href=\"#" + escapeHTML(entry.ID) + "\">" + escapeHTML(entry.Text) + "</a>"
+		} else {
+			out += escapeHTML(entry.Text)
+		}
+	}
+	for range levels {
+		out += "</li>\n</ul>\n"
+	}
+	if out != "" {
+		out += "</nav>\n"
+	}
+	return out
+}
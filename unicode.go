@@ -0,0 +1,91 @@
+package ssg
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// the markdown syntax is all ascii, so the parser can look at bytes to find it. the text
+// around it is utf-8 though, and whenever it is counted or cut it has to be done in characters
+// the way a reader sees them (graphemes): a letter with its combining marks, an emoji with its
+// modifiers or a flag made of two regional indicators is a single character
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// extendsGrapheme tells if r belongs to the character before it
+func extendsGrapheme(prev rune, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case r == '\u200d' || prev == '\u200d':
+		// zero width joiner, as used for emoji sequences
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		// skin tone modifiers
+		return true
+	case isRegionalIndicator(r) && regional%2 == 1:
+		return true
+	}
+	return false
+}
+
+// graphemes splits str into the characters a reader sees
+func graphemes(str string) []string {
+	chars := make([]string, 0, len(str))
+	start := 0
+	prev := rune(-1)
+	regional := 0
+	for i, r := range str {
+		if i > start && !extendsGrapheme(prev, r, regional) {
+			chars = append(chars, str[start:i])
+			start = i
+			regional = 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(str) {
+		chars = append(chars, str[start:])
+	}
+	return chars
+}
+
+// columns counts the characters of str
+func columns(str string) int {
+	return len(graphemes(str))
+}
+
+// isWide tells if a character takes two columns in a monospace font, like most CJK does
+func isWide(char string) bool {
+	r, _ := utf8.DecodeRuneInString(char)
+	return (r >= 0x1100 && r <= 0x115F) || (r >= 0x2E80 && r <= 0xA4CF && r != 0x303F) ||
+		(r >= 0xAC00 && r <= 0xD7A3) || (r >= 0xF900 && r <= 0xFAFF) || (r >= 0xFE30 && r <= 0xFE4F) ||
+		(r >= 0xFF00 && r <= 0xFF60) || (r >= 0xFFE0 && r <= 0xFFE6) || (r >= 0x1F300 && r <= 0x1F64F) ||
+		(r >= 0x1F900 && r <= 0x1F9FF) || (r >= 0x20000 && r <= 0x3FFFD)
+}
+
+// runeStart moves pos back to the start of the character it is in, for cutting str at pos
+func runeStart(str string, pos int) int {
+	for pos > 0 && pos < len(str) && !utf8.RuneStart(str[pos]) {
+		pos--
+	}
+	return pos
+}
+
+// width is the number of columns str takes in a monospace font
+func width(str string) int {
+	n := 0
+	for _, char := range graphemes(str) {
+		n++
+		if isWide(char) {
+			n++
+		}
+	}
+	return n
+}